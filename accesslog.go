@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// LOG_FORMAT values for the access log: one JSON object per request, or the
+// Common and Combined Log Formats web servers write.
+const (
+	logJSON     = "json"
+	logCommon   = "clf"
+	logCombined = "combined"
+)
+
+// accessEntry is what the access log records of a request. The query string
+// and headers beyond referer and user agent are left out, as they can carry
+// delete tokens, passwords and API keys.
+type accessEntry struct {
+	Time      time.Time `json:"time"`
+	IP        string    `json:"ip"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	Size      int       `json:"size"`
+	Referer   string    `json:"referer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Duration  int64     `json:"duration_ms"`
+}
+
+// responseSize is the number of body bytes resp sends.
+func responseSize(resp *events.APIGatewayProxyResponse) int {
+	if !resp.IsBase64Encoded {
+		return len(resp.Body)
+	}
+	return base64.RawStdEncoding.DecodedLen(len(strings.TrimRight(resp.Body, "=")))
+}
+
+// quoteLogField quotes s for a log line, with "-" standing in for nothing.
+func quoteLogField(s string) string {
+	if s == "" {
+		return `"-"`
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// formatAccess renders e in LOG_FORMAT.
+func formatAccess(e accessEntry) string {
+	if accessLogFormat == logJSON {
+		b, _ := json.Marshal(e)
+		return string(b)
+	}
+
+	size := "-"
+	if e.Size > 0 {
+		size = fmt.Sprint(e.Size)
+	}
+	line := fmt.Sprintf(`%s - - [%s] %s %d %s`, e.IP, e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		quoteLogField(e.Method+" "+e.Path+" HTTP/1.1"), e.Status, size)
+	if accessLogFormat == logCombined {
+		line += " " + quoteLogField(e.Referer) + " " + quoteLogField(e.UserAgent)
+	}
+	return line
+}
+
+// logAccess writes the access log line of a request handled since start.
+func logAccess(req events.APIGatewayProxyRequest, resp *events.APIGatewayProxyResponse, start time.Time) {
+	if accessLogFormat == "" {
+		return
+	}
+	fmt.Println(formatAccess(accessEntry{
+		Time:      start.UTC(),
+		IP:        req.RequestContext.Identity.SourceIP,
+		Method:    req.RequestContext.HTTPMethod,
+		Path:      req.Path,
+		Status:    resp.StatusCode,
+		Size:      responseSize(resp),
+		Referer:   header(req, "Referer"),
+		UserAgent: header(req, "User-Agent"),
+		Duration:  int64(time.Since(start) / time.Millisecond),
+	}))
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestFormatAccess(t *testing.T) {
+	e := accessEntry{
+		Time:      time.Date(2024, 6, 1, 10, 42, 7, 0, time.UTC),
+		IP:        "192.0.2.1",
+		Method:    "GET",
+		Path:      "/abcde/a.txt",
+		Status:    302,
+		Size:      120,
+		Referer:   "https://example.com/",
+		UserAgent: `curl/8.0 "quoted"`,
+		Duration:  12,
+	}
+	bare := e
+	bare.Size, bare.Referer, bare.UserAgent = 0, "", ""
+
+	tests := []struct {
+		format string
+		entry  accessEntry
+		want   string
+	}{
+		{logCommon, e, `192.0.2.1 - - [01/Jun/2024:10:42:07 +0000] "GET /abcde/a.txt HTTP/1.1" 302 120`},
+		{logCombined, e, `192.0.2.1 - - [01/Jun/2024:10:42:07 +0000] "GET /abcde/a.txt HTTP/1.1" 302 120 "https://example.com/" "curl/8.0 \"quoted\""`},
+		{logCommon, bare, `192.0.2.1 - - [01/Jun/2024:10:42:07 +0000] "GET /abcde/a.txt HTTP/1.1" 302 -`},
+		{logCombined, bare, `192.0.2.1 - - [01/Jun/2024:10:42:07 +0000] "GET /abcde/a.txt HTTP/1.1" 302 - "-" "-"`},
+		{logJSON, e, `{"time":"2024-06-01T10:42:07Z","ip":"192.0.2.1","method":"GET","path":"/abcde/a.txt","status":302,"size":120,"referer":"https://example.com/","user_agent":"curl/8.0 \"quoted\"","duration_ms":12}`},
+		{logJSON, bare, `{"time":"2024-06-01T10:42:07Z","ip":"192.0.2.1","method":"GET","path":"/abcde/a.txt","status":302,"size":0,"duration_ms":12}`},
+	}
+	for _, tt := range tests {
+		setString(t, &accessLogFormat, tt.format)
+		if got := formatAccess(tt.entry); got != tt.want {
+			t.Errorf("%s:\n got %s\nwant %s", tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestResponseSize(t *testing.T) {
+	tests := []struct {
+		resp events.APIGatewayProxyResponse
+		want int
+	}{
+		{events.APIGatewayProxyResponse{}, 0},
+		{events.APIGatewayProxyResponse{Body: "hello\n"}, 6},
+		{events.APIGatewayProxyResponse{Body: "aGVsbG8=", IsBase64Encoded: true}, 5},
+		{events.APIGatewayProxyResponse{Body: "aGVsbG8h", IsBase64Encoded: true}, 6},
+		{events.APIGatewayProxyResponse{Body: "aA==", IsBase64Encoded: true}, 1},
+	}
+	for _, tt := range tests {
+		if got := responseSize(&tt.resp); got != tt.want {
+			t.Errorf("size of %q: %d, want %d", tt.resp.Body, got, tt.want)
+		}
+	}
+}
+
+// accessLines runs f and returns the access log lines it wrote, leaving out
+// the metrics written alongside.
+func accessLines(t *testing.T, f func()) []string {
+	var lines []string
+	for _, line := range strings.Split(captureStdout(t, f), "\n") {
+		if strings.HasPrefix(line, "192.0.2.1 ") || strings.HasPrefix(line, `{"time"`) {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func TestAccessLogKeepsSecretsOut(t *testing.T) {
+	old := apiKeys
+	apiKeys = map[string]bool{"k1-secret": true}
+	defer func() { apiKeys = old }()
+
+	for _, format := range []string{logCommon, logCombined, logJSON} {
+		useTestStores(t)
+		setString(t, &accessLogFormat, format)
+
+		var token string
+		lines := accessLines(t, func() {
+			var link string
+			link, token = testUpload(t, "a.txt", "hello", map[string]string{
+				"X-API-Key":  "k1-secret",
+				"X-Password": "pw-secret",
+				"User-Agent": "curl/8.0",
+			})
+			// API Gateway sets the method on the request context only
+			req := testRequest("DELETE", link+"?token="+token, nil, "")
+			req.HTTPMethod = ""
+			serve(t, req)
+		})
+		if len(lines) != 2 {
+			t.Fatalf("%s: logged %q, want a line a request", format, lines)
+		}
+
+		for _, line := range lines {
+			for _, secret := range []string{"k1-secret", "pw-secret", token} {
+				if strings.Contains(line, secret) {
+					t.Errorf("%s: %q logged in %s", format, secret, line)
+				}
+			}
+		}
+
+		switch format {
+		case logJSON:
+			var e accessEntry
+			if err := json.Unmarshal([]byte(lines[0]), &e); err != nil || e.Method != "PUT" || e.Status != 200 || e.UserAgent != "curl/8.0" {
+				t.Errorf("json: %s, %v", lines[0], err)
+			}
+		case logCommon:
+			if !strings.Contains(lines[0], `"PUT /a.txt HTTP/1.1" 200 `) || strings.Contains(lines[0], "curl") {
+				t.Errorf("clf: %s", lines[0])
+			}
+		case logCombined:
+			if !strings.HasSuffix(lines[0], ` "-" "curl/8.0"`) || !strings.Contains(lines[1], `"DELETE /`) {
+				t.Errorf("combined: %s", lines)
+			}
+		}
+	}
+}
+
+func TestAccessLogOff(t *testing.T) {
+	useTestStores(t)
+	setString(t, &accessLogFormat, "")
+
+	if lines := accessLines(t, func() { testUpload(t, "a.txt", "hello", nil) }); len(lines) != 0 {
+		t.Errorf("logged %q with LOG_FORMAT unset", lines)
+	}
+}
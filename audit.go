@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// auditEvent is one entry of the audit trail. It must never hold bearer
+// credentials such as presigned urls or delete tokens.
+type auditEvent struct {
+	ID      string            `json:"id"`
+	Event   string            `json:"event"`
+	Key     string            `json:"key,omitempty"`
+	IP      string            `json:"ip,omitempty"`
+	At      int64             `json:"at"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// audit records ev in AUDIT_TABLE, or in the function log when no table is
+// configured. Failures are logged and otherwise ignored.
+func audit(ctx context.Context, ev auditEvent) {
+	b := make([]byte, 8)
+	rand.Read(b)
+	ev.ID = hex.EncodeToString(b)
+	ev.At = time.Now().Unix()
+
+	if auditTable == "" {
+		line, _ := json.Marshal(ev)
+		log.Printf("audit %s", line)
+		return
+	}
+
+	av, err := dynamodbattribute.MarshalMap(ev)
+	if err == nil {
+		_, err = dynamodb.New(sess).PutItemWithContext(ctx, &dynamodb.PutItemInput{
+			Item:      av,
+			TableName: aws.String(auditTable),
+		})
+	}
+	if err != nil {
+		log.Printf("audit %s %s: %v", ev.Event, ev.Key, err)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+// presignEvents returns the presign entries PutItem calls wrote to the
+// audit table.
+func presignEvents(t *testing.T, calls []s3Call) []map[string]interface{} {
+	t.Helper()
+
+	var events []map[string]interface{}
+	for _, c := range calls {
+		if c.header.Get("X-Amz-Target") != "DynamoDB_20120810.PutItem" || !strings.Contains(c.body, `"presign"`) {
+			continue
+		}
+		var in struct {
+			TableName string
+			Item      map[string]interface{}
+		}
+		if err := json.Unmarshal([]byte(c.body), &in); err != nil {
+			t.Fatal(err)
+		}
+		if in.TableName != "audit" {
+			t.Errorf("audited to table %q", in.TableName)
+		}
+		events = append(events, in.Item)
+	}
+	return events
+}
+
+func TestAuditPresign(t *testing.T) {
+	tests := []struct {
+		name   string
+		on     bool
+		mode   string
+		target string
+		want   int
+	}{
+		{"off", false, downloadRedirect, "", 0},
+		{"redirect", true, downloadRedirect, "", 1},
+		{"raw url", true, downloadRedirect, "/url", 1},
+		{"proxied", true, downloadProxy, "", 0},
+	}
+	for _, tt := range tests {
+		useTestStores(t)
+		_, calls := useTestS3(t, ok)
+		setString(t, &auditTable, "audit")
+		setBool(t, &auditPresign, tt.on)
+		setString(t, &downloadMode, tt.mode)
+
+		link, _ := testUpload(t, "a.txt", "hello", nil)
+		*calls = nil
+		resp := serve(t, testRequest("GET", tt.target+link, nil, ""))
+		if resp.StatusCode >= 300 && resp.StatusCode != http.StatusFound {
+			t.Fatalf("%s: download: %d", tt.name, resp.StatusCode)
+		}
+
+		events := presignEvents(t, *calls)
+		if len(events) != tt.want {
+			t.Errorf("%s: %d presign entries, want %d", tt.name, len(events), tt.want)
+		}
+		for _, c := range *calls {
+			if strings.Contains(c.body, "files.test") {
+				t.Errorf("%s: audit entry holds the url: %s", tt.name, c.body)
+			}
+		}
+		for _, ev := range events {
+			b, _ := json.Marshal(ev)
+			for _, want := range []string{keyOf(link), "192.0.2.1", `"ttl"`, `"at"`} {
+				if !strings.Contains(string(b), want) {
+					t.Errorf("%s: entry %s lacks %s", tt.name, b, want)
+				}
+			}
+		}
+	}
+}
+
+func TestAuditToLog(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	setString(t, &auditTable, "")
+
+	audit(context.Background(), auditEvent{Event: "presign", Key: "abcde", IP: "192.0.2.1"})
+	if line := buf.String(); !strings.Contains(line, `audit {"id":"`) || !strings.Contains(line, `"key":"abcde"`) {
+		t.Errorf("logged %q", line)
+	}
+}
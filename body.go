@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// sniffLen is how much of a body content type detection looks at.
+const sniffLen = 512
+
+var errBadSeek = errors.New("invalid seek")
+
+// uploadBody is a request body read where it lies. A base64 body is decoded
+// as it is read rather than all at once, so an upload never holds a second,
+// decoded copy of itself.
+type uploadBody struct {
+	src string
+	b64 bool
+
+	// set by newUploadBody, which reads the body through once
+	size   int64
+	sha256 string
+	head   []byte
+}
+
+// newUploadBody reads the body of req through once, to size and hash it and
+// keep its first bytes for content sniffing. It fails on invalid base64.
+func newUploadBody(req events.APIGatewayProxyRequest) (*uploadBody, error) {
+	b := &uploadBody{src: req.Body, b64: req.IsBase64Encoded}
+
+	h := sha256.New()
+	head := &prefixWriter{max: sniffLen}
+	n, err := io.Copy(io.MultiWriter(h, head), b.Open())
+	if err != nil {
+		return nil, err
+	}
+
+	b.size, b.head = n, head.buf
+	b.sha256 = hex.EncodeToString(h.Sum(nil))
+	return b, nil
+}
+
+// Open returns a reader over the decoded body, starting at its beginning.
+func (b *uploadBody) Open() io.ReadSeeker {
+	if !b.b64 {
+		return strings.NewReader(b.src)
+	}
+	return &base64Reader{body: b, r: b.decoder()}
+}
+
+func (b *uploadBody) decoder() io.Reader {
+	return base64.NewDecoder(base64.StdEncoding, strings.NewReader(b.src))
+}
+
+// ReadAll returns the whole decoded body, for inspections that cannot work
+// on a stream.
+func (b *uploadBody) ReadAll() ([]byte, error) {
+	if !b.b64 {
+		return []byte(b.src), nil
+	}
+	return ioutil.ReadAll(b.decoder())
+}
+
+// base64Reader decodes a base64 body while it is read. Seeking backwards
+// starts decoding over, which is what a retried upload does.
+type base64Reader struct {
+	body *uploadBody
+	r    io.Reader
+	pos  int64
+}
+
+func (r *base64Reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.pos += int64(n)
+	return n, err
+}
+
+func (r *base64Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.body.size
+	}
+	if offset < 0 || offset > r.body.size {
+		return r.pos, errBadSeek
+	}
+
+	if offset < r.pos {
+		r.r, r.pos = r.body.decoder(), 0
+	}
+	if _, err := io.CopyN(ioutil.Discard, r, offset-r.pos); err != nil {
+		return r.pos, err
+	}
+	return r.pos, nil
+}
+
+// prefixWriter keeps the first max bytes written to it.
+type prefixWriter struct {
+	buf []byte
+	max int
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	if room := w.max - len(w.buf); room > 0 {
+		if len(p) < room {
+			room = len(p)
+		}
+		w.buf = append(w.buf, p[:room]...)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// readChunks reads r through in reads of n bytes.
+func readChunks(r io.Reader, n int) ([]byte, error) {
+	var out []byte
+	buf := make([]byte, n)
+	for {
+		m, err := r.Read(buf)
+		out = append(out, buf[:m]...)
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return out, err
+		}
+	}
+}
+
+func TestStreamedBase64(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	// lengths on both sides of the 3-byte base64 block and the sniff prefix
+	for _, size := range []int{0, 1, 2, 3, 4, 5, 511, 512, 513, 4096, 100001} {
+		data := make([]byte, size)
+		rnd.Read(data)
+		req := events.APIGatewayProxyRequest{Body: base64.StdEncoding.EncodeToString(data), IsBase64Encoded: true}
+
+		whole, err := base64.StdEncoding.DecodeString(req.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := newUploadBody(req)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		sum := sha256.Sum256(whole)
+		if b.size != int64(len(whole)) || b.sha256 != hex.EncodeToString(sum[:]) {
+			t.Errorf("%d bytes: size %d, hash %s", size, b.size, b.sha256)
+		}
+		if n := len(whole); !bytes.Equal(b.head, whole[:minInt(n, sniffLen)]) {
+			t.Errorf("%d bytes: head of %d bytes differs", size, len(b.head))
+		}
+
+		for _, chunk := range []int{1, 7, 32 << 10} {
+			streamed, err := readChunks(b.Open(), chunk)
+			if err != nil || !bytes.Equal(streamed, whole) {
+				t.Errorf("%d bytes read by %d: %d bytes, %v", size, chunk, len(streamed), err)
+			}
+		}
+		if all, err := b.ReadAll(); err != nil || !bytes.Equal(all, whole) {
+			t.Errorf("%d bytes: ReadAll differs, %v", size, err)
+		}
+	}
+}
+
+// minInt is the smaller of a and b.
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func TestPlainBody(t *testing.T) {
+	b, err := newUploadBody(events.APIGatewayProxyRequest{Body: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := ioutil.ReadAll(b.Open())
+	if string(got) != "hello" || b.size != 5 || string(b.head) != "hello" {
+		t.Errorf("plain body read as %q, size %d", got, b.size)
+	}
+}
+
+func TestInvalidBase64(t *testing.T) {
+	for _, body := range []string{"aGVsbG8", "aGVs!G8=", "aGVsbG8=aGVs"} {
+		if _, err := newUploadBody(events.APIGatewayProxyRequest{Body: body, IsBase64Encoded: true}); err == nil {
+			t.Errorf("%q accepted", body)
+		}
+	}
+}
+
+func TestBase64Seek(t *testing.T) {
+	data := []byte("the quick brown fox jumps over the lazy dog")
+	b, err := newUploadBody(events.APIGatewayProxyRequest{Body: base64.StdEncoding.EncodeToString(data), IsBase64Encoded: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := b.Open()
+	io.CopyN(ioutil.Discard, r, 20)
+
+	tests := []struct {
+		offset int64
+		whence int
+		pos    int64
+		err    error
+	}{
+		{0, io.SeekCurrent, 20, nil},
+		{4, io.SeekStart, 4, nil},
+		{6, io.SeekCurrent, 10, nil},
+		{-3, io.SeekEnd, int64(len(data)) - 3, nil},
+		{0, io.SeekStart, 0, nil},
+		{-1, io.SeekStart, 0, errBadSeek},
+		{1, io.SeekEnd, 0, errBadSeek},
+	}
+	for _, tt := range tests {
+		pos, err := r.Seek(tt.offset, tt.whence)
+		if pos != tt.pos || err != tt.err {
+			t.Fatalf("Seek(%d, %d) = %d, %v; want %d, %v", tt.offset, tt.whence, pos, err, tt.pos, tt.err)
+		}
+		if err != nil {
+			continue
+		}
+		rest, _ := ioutil.ReadAll(r)
+		if !bytes.Equal(rest, data[pos:]) {
+			t.Errorf("after Seek(%d, %d): read %q", tt.offset, tt.whence, rest)
+		}
+		r.Seek(pos, io.SeekStart)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// budgetRetries is how often a single AWS call may be retried when
+// RETRY_BUDGET is set; the budget, not the count, is meant to be the limit.
+const budgetRetries = 5
+
+// retryBudget is the time one request may spend retrying AWS calls, the
+// delays and the retried attempts alike, shared by all the calls it makes. The SDK retries every call on its own
+// schedule otherwise, and a handful of slow calls can add up to the whole
+// Lambda timeout.
+type retryBudget struct {
+	mu    sync.Mutex
+	left  time.Duration
+	spent bool
+}
+
+type retryBudgetKey struct{}
+
+// withRetryBudget gives the request handled under ctx a RETRY_BUDGET.
+func withRetryBudget(ctx context.Context) context.Context {
+	if retryBudgetLimit <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, retryBudgetKey{}, &retryBudget{left: retryBudgetLimit})
+}
+
+// retryBudgetOf returns the budget of ctx, or nil when there is none.
+func retryBudgetOf(ctx context.Context) *retryBudget {
+	b, _ := ctx.Value(retryBudgetKey{}).(*retryBudget)
+	return b
+}
+
+// take spends d on a retry, reporting false, and marking the budget spent,
+// when d is more than is left. A nil budget never runs out.
+func (b *retryBudget) take(d time.Duration) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if d > b.left {
+		b.spent = true
+		return false
+	}
+	b.left -= d
+	return true
+}
+
+// exhausted reports whether a retry was given up for lack of budget.
+func (b *retryBudget) exhausted() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.spent
+}
+
+// budgetRetryer retries AWS calls like the SDK does, but only while the
+// retry budget of the request's context lasts. It needs
+// EnforceShouldRetryCheck so that every retry goes through ShouldRetry.
+type budgetRetryer struct {
+	client.DefaultRetryer
+
+	// backoff, when set, replaces the SDK's delay before retry number n
+	backoff func(n int) time.Duration
+}
+
+func newBudgetRetryer() budgetRetryer {
+	return budgetRetryer{DefaultRetryer: client.DefaultRetryer{NumMaxRetries: budgetRetries}}
+}
+
+// ShouldRetry settles the delay of the retry as well, so that it can be
+// taken from the budget before the retry is committed to. A retried attempt
+// that failed is taken along with it; one that succeeds ends the call and
+// is not charged.
+func (r budgetRetryer) ShouldRetry(req *request.Request) bool {
+	retry := r.DefaultRetryer.ShouldRetry(req)
+	if req.Retryable != nil {
+		retry = *req.Retryable
+	}
+	if !retry || req.RetryCount >= r.MaxRetries() {
+		return false
+	}
+
+	if r.backoff != nil {
+		req.RetryDelay = r.backoff(req.RetryCount)
+	} else {
+		req.RetryDelay = r.DefaultRetryer.RetryRules(req)
+	}
+	cost := req.RetryDelay
+	if req.RetryCount > 0 {
+		cost += time.Since(req.AttemptTime)
+	}
+	return retryBudgetOf(req.Context()).take(cost)
+}
+
+// RetryRules returns the delay ShouldRetry settled on.
+func (r budgetRetryer) RetryRules(req *request.Request) time.Duration {
+	return req.RetryDelay
+}
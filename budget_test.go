@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+func TestRetryBudgetTake(t *testing.T) {
+	tests := []struct {
+		limit time.Duration
+		takes []time.Duration
+		ok    []bool
+	}{
+		{time.Second, []time.Duration{400 * time.Millisecond, 600 * time.Millisecond, 0}, []bool{true, true, true}},
+		{time.Second, []time.Duration{800 * time.Millisecond, 300 * time.Millisecond, 200 * time.Millisecond}, []bool{true, false, true}},
+		{0, []time.Duration{0, time.Hour}, []bool{true, true}},
+	}
+	for _, tt := range tests {
+		setDuration(t, &retryBudgetLimit, tt.limit)
+		b := retryBudgetOf(withRetryBudget(context.Background()))
+		if (b == nil) != (tt.limit == 0) {
+			t.Fatalf("budget of %v: %v", tt.limit, b)
+		}
+
+		spent := false
+		for i, d := range tt.takes {
+			if ok := b.take(d); ok != tt.ok[i] {
+				t.Errorf("budget of %v: take %d of %v = %v", tt.limit, i, d, ok)
+			}
+			spent = spent || !tt.ok[i]
+		}
+		if b.exhausted() != spent {
+			t.Errorf("budget of %v: exhausted %v, want %v", tt.limit, b.exhausted(), spent)
+		}
+	}
+}
+
+// useBudgetRetries makes the AWS session retry every call like RETRY_BUDGET
+// does, waiting backoff before each retry.
+func useBudgetRetries(t *testing.T, backoff time.Duration) {
+	cfg := request.WithRetryer(sess.Config.Copy(), budgetRetryer{
+		DefaultRetryer: client.DefaultRetryer{NumMaxRetries: budgetRetries},
+		backoff:        func(int) time.Duration { return backoff },
+	})
+	cfg.EnforceShouldRetryCheck = aws.Bool(true)
+
+	old := sess
+	sess = session.Must(session.NewSession(cfg))
+	t.Cleanup(func() { sess = old })
+}
+
+func TestRetryBudgetShared(t *testing.T) {
+	tests := []struct {
+		limit, slow time.Duration
+		attempts    int
+	}{
+		// one call can retry budgetRetries times when the budget lasts
+		{time.Second, 0, 2 * (1 + budgetRetries)},
+		// two retries of 10ms fit in 25ms, then both calls are out
+		{25 * time.Millisecond, 0, 3 + 1},
+		{5 * time.Millisecond, 0, 1 + 1},
+		// slow retried attempts are charged with their delays: 10ms and
+		// 10ms+20ms for the first call, 10ms for the second fit in 60ms
+		{60 * time.Millisecond, 20 * time.Millisecond, 3 + 2},
+	}
+	for _, tt := range tests {
+		slow := tt.slow
+		st, calls := useTestS3(t, func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(slow)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		})
+		useBudgetRetries(t, 10*time.Millisecond)
+		setDuration(t, &retryBudgetLimit, tt.limit)
+
+		ctx := withRetryBudget(context.Background())
+		start := time.Now()
+		for i := 0; i < 2; i++ {
+			if _, err := st.Exists(ctx, "k", ""); err == nil {
+				t.Fatalf("budget of %v: call %d succeeded", tt.limit, i)
+			}
+		}
+		if len(*calls) != tt.attempts {
+			t.Errorf("budget of %v: %d attempts, want %d", tt.limit, len(*calls), tt.attempts)
+		}
+		if waited := time.Since(start); tt.limit < time.Second && waited > tt.limit+time.Second/2 {
+			t.Errorf("budget of %v: waited %v", tt.limit, waited)
+		}
+		if got, want := retryBudgetOf(ctx).exhausted(), tt.limit < time.Second; got != want {
+			t.Errorf("budget of %v: exhausted %v, want %v", tt.limit, got, want)
+		}
+	}
+}
+
+func TestRetryBudgetSpentIs503(t *testing.T) {
+	useTestStores(t)
+	s3st, _ := useTestS3(t, failingS3(1<<20, http.StatusServiceUnavailable))
+	objects = s3st
+	setInt(t, &putRetries, 10)
+
+	tests := []struct {
+		limit, base time.Duration
+		status      int
+	}{
+		// quick retries without a delay do not spend the budget
+		{0, 0, http.StatusInternalServerError},
+		{15 * time.Millisecond, 0, http.StatusInternalServerError},
+		{15 * time.Millisecond, 10 * time.Millisecond, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		setDuration(t, &retryBudgetLimit, tt.limit)
+		setDuration(t, &putRetryBase, tt.base)
+
+		resp, err := handleRequest(context.Background(), testRequest("PUT", "/a.txt", nil, "hello"))
+		if resp.StatusCode != tt.status {
+			t.Errorf("budget of %v, base %v: %d, %v; want %d", tt.limit, tt.base, resp.StatusCode, err, tt.status)
+		}
+		if tt.status == http.StatusServiceUnavailable && (err != nil || resp.Headers["Retry-After"] == "" || !strings.Contains(resp.Body, "try again")) {
+			t.Errorf("budget of %v: %v %q, %v", tt.limit, resp.Headers, resp.Body, err)
+		}
+	}
+}
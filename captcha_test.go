@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// useTestCaptcha points the captcha check at a provider that accepts only
+// the token "good", or answers status when it is not 200.
+func useTestCaptcha(t *testing.T, status int) *[]string {
+	t.Helper()
+
+	var tokens []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("secret") != "s3cret" {
+			t.Errorf("verify sent secret %q", r.FormValue("secret"))
+		}
+		tokens = append(tokens, r.FormValue("response"))
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]bool{"success": r.FormValue("response") == "good"})
+	}))
+	t.Cleanup(srv.Close)
+
+	setString(t, &captchaVerifyURL, srv.URL)
+	setString(t, &captchaSecret, "s3cret")
+	return &tokens
+}
+
+func TestCaptchaUpload(t *testing.T) {
+	useTestStores(t)
+	verified := useTestCaptcha(t, http.StatusOK)
+
+	old := apiKeys
+	apiKeys = map[string]bool{"k1": true}
+	defer func() { apiKeys = old }()
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    int
+		checked bool
+	}{
+		{"solved", map[string]string{"X-Captcha-Token": "good"}, http.StatusOK, true},
+		{"wrong token", map[string]string{"X-Captcha-Token": "bad"}, http.StatusForbidden, true},
+		{"no token", nil, http.StatusForbidden, false},
+		{"api key", map[string]string{"X-API-Key": "k1"}, http.StatusOK, false},
+		{"api key and token", map[string]string{"X-API-Key": "k1", "X-Captcha-Token": "bad"}, http.StatusOK, false},
+	}
+	for _, tt := range tests {
+		*verified = nil
+		resp := serve(t, testRequest("PUT", "/a.txt", tt.headers, "hello"))
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s: %d, want %d", tt.name, resp.StatusCode, tt.want)
+		}
+		if (len(*verified) > 0) != tt.checked {
+			t.Errorf("%s: verified %q", tt.name, *verified)
+		}
+	}
+}
+
+func TestCaptchaProviderDown(t *testing.T) {
+	useTestStores(t)
+	useTestCaptcha(t, http.StatusInternalServerError)
+
+	resp := serve(t, testRequest("PUT", "/a.txt", map[string]string{"X-Captcha-Token": "good"}, "hello"))
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("upload with the provider down: %d, want 503", resp.StatusCode)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// acceptsGzip reports whether the client listed gzip in Accept-Encoding
+// without refusing it through q=0.
+func acceptsGzip(req events.APIGatewayProxyRequest) bool {
+	for _, part := range strings.Split(header(req, "Accept-Encoding"), ",") {
+		fields := strings.Split(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(fields[0]), "gzip") {
+			continue
+		}
+		for _, p := range fields[1:] {
+			p = strings.TrimSpace(p)
+			if !strings.HasPrefix(p, "q=") {
+				continue
+			}
+			if q, err := strconv.ParseFloat(p[2:], 64); err == nil && q == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// compressResponse gzips bodies of at least compressMinSize bytes for
+// clients that accept it. Smaller bodies are left alone: compressing them
+// costs more than it saves. A negative threshold disables compression.
+func compressResponse(req events.APIGatewayProxyRequest, resp *events.APIGatewayProxyResponse) {
+	if compressMinSize < 0 || resp.Body == "" || !acceptsGzip(req) {
+		return
+	}
+	if _, ok := resp.Headers["Content-Encoding"]; ok {
+		return
+	}
+
+	body := []byte(resp.Body)
+	if resp.IsBase64Encoded {
+		var err error
+		if body, err = base64.StdEncoding.DecodeString(resp.Body); err != nil {
+			return
+		}
+	}
+	if len(body) < compressMinSize {
+		return
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(body); err != nil {
+		return
+	}
+	if err := zw.Close(); err != nil {
+		return
+	}
+
+	if resp.Headers == nil {
+		resp.Headers = map[string]string{}
+	}
+	resp.Headers["Content-Encoding"] = "gzip"
+	resp.Headers["Vary"] = "Accept-Encoding"
+	if _, ok := resp.Headers["Content-Length"]; ok {
+		resp.Headers["Content-Length"] = strconv.Itoa(buf.Len())
+	}
+	resp.Body = base64.StdEncoding.EncodeToString(buf.Bytes())
+	resp.IsBase64Encoded = true
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := map[string]bool{
+		"":                    false,
+		"gzip":                true,
+		"deflate, gzip;q=0.8": true,
+		"GZIP":                true,
+		"gzip;q=0":            false,
+		"br, deflate":         false,
+		"gzipped":             false,
+	}
+	for v, want := range tests {
+		req := testRequest("GET", "/", map[string]string{"Accept-Encoding": v}, "")
+		if acceptsGzip(req) != want {
+			t.Errorf("Accept-Encoding %q: %v, want %v", v, !want, want)
+		}
+	}
+}
+
+func TestCompressThreshold(t *testing.T) {
+	req := testRequest("GET", "/", map[string]string{"Accept-Encoding": "gzip"}, "")
+
+	tests := []struct {
+		size int
+		min  int
+		want bool
+	}{
+		{1023, 1024, false},
+		{1024, 1024, true},
+		{4096, 1024, true},
+		{0, 0, false},
+		{10, 0, true},
+		{4096, -1, false},
+	}
+	for _, tt := range tests {
+		setInt(t, &compressMinSize, tt.min)
+		body := strings.Repeat("a", tt.size)
+		resp := events.APIGatewayProxyResponse{Body: body, Headers: map[string]string{"Content-Length": "x"}}
+		compressResponse(req, &resp)
+
+		if got := resp.Headers["Content-Encoding"] == "gzip"; got != tt.want {
+			t.Errorf("%d bytes, threshold %d: compressed %v", tt.size, tt.min, got)
+			continue
+		}
+		if tt.want && gunzip(t, resp) != body {
+			t.Errorf("%d bytes: body does not round trip", tt.size)
+		}
+	}
+}
+
+func TestCompressBinaryBody(t *testing.T) {
+	setInt(t, &compressMinSize, 10)
+	body := strings.Repeat("\x00\x01", 100)
+	resp := events.APIGatewayProxyResponse{
+		Body:            base64.StdEncoding.EncodeToString([]byte(body)),
+		IsBase64Encoded: true,
+		Headers:         map[string]string{"Content-Length": "200"},
+	}
+	compressResponse(testRequest("GET", "/", map[string]string{"Accept-Encoding": "gzip"}, ""), &resp)
+	if gunzip(t, resp) != body {
+		t.Error("binary body does not round trip")
+	}
+	if resp.Headers["Content-Length"] == "200" {
+		t.Error("Content-Length left at the uncompressed size")
+	}
+}
+
+func gunzip(t *testing.T, resp events.APIGatewayProxyResponse) string {
+	t.Helper()
+
+	zr, err := gzip.NewReader(bytes.NewReader([]byte(responseBody(t, resp))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// downloadSlot is a place taken in the concurrent download counter of a
+// link. Redirected downloads happen out of our sight, so a slot is held for
+// the rest of its window of downloadSlotTTL rather than until the transfer
+// ends; the counter thus tracks downloads started within the window.
+type downloadSlot struct {
+	key    string
+	expire time.Time
+}
+
+// concurrencyLimit is the cap on concurrent downloads of item, or zero. An
+// upload may lower the global cap but not raise it.
+func concurrencyLimit(item *transferItem) int {
+	limit := maxConcurrentDownloads
+	if item.MaxConcurrent > 0 && (limit == 0 || item.MaxConcurrent < limit) {
+		limit = item.MaxConcurrent
+	}
+	return limit
+}
+
+// acquireDownloadSlot takes a slot for a download of item, failing with
+// errLimitReached when all are in use. It returns nil when item is not
+// capped.
+func acquireDownloadSlot(ctx context.Context, item *transferItem) (*downloadSlot, error) {
+	limit := concurrencyLimit(item)
+	if limit == 0 {
+		return nil, nil
+	}
+
+	start := time.Now().Truncate(downloadSlotTTL)
+	slot := &downloadSlot{
+		key:    fmt.Sprintf("active#%s#%d", item.S3Key, start.Unix()),
+		expire: start.Add(downloadSlotTTL),
+	}
+
+	err := meta.Incr(ctx, slot.key,
+		map[string]int64{"active": 1},
+		map[string]int64{"active": int64(limit)},
+		slot.expire.Unix())
+	if err != nil {
+		return nil, err
+	}
+	return slot, nil
+}
+
+// release gives the slot back, for downloads that end or never start.
+func (slot *downloadSlot) release(ctx context.Context) error {
+	if slot == nil {
+		return nil
+	}
+	return meta.Incr(ctx, slot.key, map[string]int64{"active": -1}, nil, slot.expire.Unix())
+}
+
+// retryAfter is the number of seconds until slots of the current window
+// lapse.
+func retryAfter(now time.Time) int64 {
+	left := now.Truncate(downloadSlotTTL).Add(downloadSlotTTL).Sub(now)
+	return int64(left/time.Second) + 1
+}
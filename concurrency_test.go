@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimit(t *testing.T) {
+	tests := []struct {
+		global, upload int
+		want           int
+	}{
+		{0, 0, 0},
+		{0, 3, 3},
+		{5, 0, 5},
+		{5, 3, 3},
+		{5, 8, 5},
+	}
+	for _, tt := range tests {
+		setInt(t, &maxConcurrentDownloads, tt.global)
+		if got := concurrencyLimit(&transferItem{MaxConcurrent: tt.upload}); got != tt.want {
+			t.Errorf("global %d, upload %d: %d, want %d", tt.global, tt.upload, got, tt.want)
+		}
+	}
+}
+
+func TestConcurrentDownloadCap(t *testing.T) {
+	tests := []struct {
+		name    string
+		global  int
+		upload  string
+		mode    string
+		allowed int
+	}{
+		{"global cap", 2, "", downloadRedirect, 2},
+		{"upload cap", 0, "1", downloadRedirect, 1},
+		{"upload under global", 3, "2", downloadRedirect, 2},
+		{"proxied give slots back", 1, "", downloadProxy, 5},
+	}
+	for _, tt := range tests {
+		useTestStores(t)
+		setInt(t, &maxDownloads, 100)
+		setInt(t, &maxConcurrentDownloads, tt.global)
+		setDuration(t, &downloadSlotTTL, time.Hour)
+		setString(t, &downloadMode, tt.mode)
+
+		link, _ := testUpload(t, "a.txt", "hello", map[string]string{"X-Max-Concurrent": tt.upload})
+		for i := 0; i < 5; i++ {
+			resp := serve(t, testRequest("GET", link, nil, ""))
+			if i < tt.allowed {
+				if resp.StatusCode >= 300 && resp.StatusCode != http.StatusFound {
+					t.Errorf("%s: download %d: %d", tt.name, i, resp.StatusCode)
+				}
+				continue
+			}
+			if resp.StatusCode != http.StatusTooManyRequests {
+				t.Errorf("%s: download %d: %d, want 429", tt.name, i, resp.StatusCode)
+			}
+			if s, err := strconv.Atoi(resp.Headers["Retry-After"]); err != nil || s < 1 || s > 3601 {
+				t.Errorf("%s: Retry-After %q", tt.name, resp.Headers["Retry-After"])
+			}
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	setDuration(t, &downloadSlotTTL, time.Minute)
+	at := time.Date(2024, 6, 1, 10, 0, 45, 0, time.UTC)
+	if got := retryAfter(at); got != 16 {
+		t.Errorf("retryAfter(%v) = %d, want 16", at, got)
+	}
+}
+
+func TestBadMaxConcurrent(t *testing.T) {
+	useTestStores(t)
+	for _, v := range []string{"-1", "many"} {
+		resp := serve(t, testRequest("PUT", "/a.txt", map[string]string{"X-Max-Concurrent": v}, "hello"))
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("X-Max-Concurrent %q: %d, want 400", v, resp.StatusCode)
+		}
+	}
+}
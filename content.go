@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+const (
+	missingFilenameKey    = "key"
+	missingFilenameReject = "reject"
+)
+
+// usableFilename reports whether name is worth keeping. curl names piped
+// uploads ("curl --upload-file -") after stdin, which tells downloaders
+// nothing.
+func usableFilename(name string) bool {
+	switch name {
+	case "", "-", "stdin":
+		return false
+	}
+	return true
+}
+
+// fallbackFilename names an upload that came without a usable filename:
+// DEFAULT_FILENAME, or the key itself, with an extension for contentType.
+func fallbackFilename(key, contentType string) string {
+	base := defaultFilename
+	if base == "" {
+		base = key
+	}
+	return base + extensionFor(contentType)
+}
+
+// downloadName is the filename to serve item under, standing in for one the
+// record lacks.
+func downloadName(item *transferItem) string {
+	if usableFilename(item.Filename) {
+		return item.Filename
+	}
+	return fallbackFilename(item.S3Key, item.ContentType)
+}
+
+// preferredExtensions picks the usual extension for types where the system
+// mime table lists several.
+var preferredExtensions = map[string]string{
+	"text/plain":               ".txt",
+	"text/html":                ".html",
+	"image/jpeg":               ".jpg",
+	"image/png":                ".png",
+	"image/gif":                ".gif",
+	"application/pdf":          ".pdf",
+	"application/zip":          ".zip",
+	"application/x-gzip":       ".gz",
+	"application/json":         ".json",
+	"application/octet-stream": ".bin",
+}
+
+// uploadContentType trusts the client's Content-Type unless it is missing or
+// merely the default curl attaches to --data, and sniffs the body otherwise.
+func uploadContentType(req events.APIGatewayProxyRequest, body []byte) string {
+	ct := header(req, "Content-Type")
+	if mt, _, err := mime.ParseMediaType(ct); err == nil && mt != "application/x-www-form-urlencoded" {
+		return ct
+	}
+	return http.DetectContentType(body)
+}
+
+// extensionFor guesses a filename extension for contentType, or returns an
+// empty string when it has none.
+func extensionFor(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	if ext, ok := preferredExtensions[mt]; ok {
+		return ext
+	}
+	if exts, err := mime.ExtensionsByType(mt); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+// allowedExtension reports whether ext, such as ".PDF", is in
+// ALLOWED_EXTENSIONS. Every extension is allowed when the list is empty.
+func allowedExtension(ext string) bool {
+	return len(allowedExtensions) == 0 || allowedExtensions[strings.ToLower(ext)]
+}
+
+// mediaType strips the parameters off a content type.
+func mediaType(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return mt
+}
+
+// sniffCompatible reports whether content sniffed as sniffed may carry a
+// name whose extension maps to declared. Office documents, for one, sniff
+// as the zip containers they are.
+func sniffCompatible(sniffed, declared string) bool {
+	switch {
+	case sniffed == declared:
+		return true
+	case sniffed == "application/zip":
+		return strings.Contains(declared, "openxmlformats") ||
+			strings.Contains(declared, "opendocument") ||
+			strings.Contains(declared, "epub") ||
+			strings.Contains(declared, "java-archive")
+	case sniffed == "text/xml":
+		return strings.HasSuffix(declared, "+xml") || declared == "application/xml"
+	}
+	return false
+}
+
+// mislabeled reports whether body is evidently not what the extension of
+// filename claims. Content that sniffs as generic text or binary, or an
+// extension of unknown type, cannot be judged and passes.
+func mislabeled(filename string, body []byte) bool {
+	declared := mediaType(mime.TypeByExtension(path.Ext(filename)))
+	sniffed := mediaType(http.DetectContentType(body))
+
+	switch {
+	case declared == "":
+		return false
+	case sniffed == "application/octet-stream", sniffed == "text/plain":
+		return false
+	}
+	return !sniffCompatible(sniffed, declared)
+}
+
+// sniffLegacyType fills in the content type of a record made before types
+// were recorded, from the first bytes of its object, and saves it on the
+// record so that the object is read only once. It is off unless
+// SNIFF_LEGACY is set, as it costs a ranged read of the object per request
+// until the record is updated. Failures leave the record untyped.
+func sniffLegacyType(ctx context.Context, item *transferItem) {
+	if !sniffLegacy || item.ContentType != "" {
+		return
+	}
+
+	head, err := objects.Peek(ctx, item.ObjectPath(), item.VersionID, sniffLen)
+	if err != nil {
+		log.Printf("sniff %s: %v", item.S3Key, err)
+		return
+	}
+
+	contentType := http.DetectContentType(head)
+	if err := meta.SetString(ctx, item.S3Key, "content_type", contentType); err != nil {
+		log.Printf("sniff %s: %v", item.S3Key, err)
+		return
+	}
+	item.ContentType = contentType
+
+	if item.SniffedType == "" {
+		item.SniffedType = mediaType(contentType)
+		if err := meta.SetString(ctx, item.S3Key, "sniffed_type", item.SniffedType); err != nil {
+			log.Printf("sniff %s: %v", item.S3Key, err)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestFallbackFilename(t *testing.T) {
+	tests := []struct {
+		def, key, contentType string
+		want                  string
+	}{
+		{"", "abcde", "text/plain; charset=utf-8", "abcde.txt"},
+		{"", "abcde", "image/png", "abcde.png"},
+		{"", "abcde", "application/octet-stream", "abcde.bin"},
+		{"", "abcde", "", "abcde"},
+		{"upload", "abcde", "application/pdf", "upload.pdf"},
+	}
+	for _, tt := range tests {
+		setString(t, &defaultFilename, tt.def)
+		if got := fallbackFilename(tt.key, tt.contentType); got != tt.want {
+			t.Errorf("fallbackFilename(%q, %q) with default %q = %q, want %q", tt.key, tt.contentType, tt.def, got, tt.want)
+		}
+	}
+}
+
+func TestUsableFilename(t *testing.T) {
+	for name, want := range map[string]bool{"": false, "-": false, "stdin": false, "a.txt": true, "stdin.txt": true} {
+		if usableFilename(name) != want {
+			t.Errorf("usableFilename(%q) = %v", name, !want)
+		}
+	}
+}
+
+func TestUploadWithoutFilename(t *testing.T) {
+	useTestStores(t)
+
+	tests := []struct {
+		name string
+		want func(key string) string
+	}{
+		{"a.txt", func(string) string { return "a.txt" }},
+		{"-", func(key string) string { return key + ".txt" }},
+		{"stdin", func(key string) string { return key + ".txt" }},
+	}
+	for _, tt := range tests {
+		link, _ := testUpload(t, tt.name, "hello", nil)
+		item, err := meta.Get(context.Background(), keyOf(link))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := tt.want(item.S3Key); item.Filename != want || link != "/"+item.S3Key+"/"+want {
+			t.Errorf("upload as %q: stored %q at %s, want %q", tt.name, item.Filename, link, want)
+		}
+	}
+
+	setString(t, &missingFilename, missingFilenameReject)
+	if resp := serve(t, testRequest("PUT", "/-", nil, "hello")); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("MISSING_FILENAME=reject: %d, want 400", resp.StatusCode)
+	}
+	testUpload(t, "a.txt", "hello", nil)
+}
+
+func TestPipedUploadDefaultFilename(t *testing.T) {
+	useTestStores(t)
+	setString(t, &defaultFilename, "upload")
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		body    string
+		want    string
+	}{
+		{"-", nil, "plain text", "upload.txt"},
+		{"-", map[string]string{"Content-Type": "application/pdf"}, "%PDF-1.4", "upload.pdf"},
+		{"stdin", nil, "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR", "upload.png"},
+		{"report.csv", nil, "a,b", "report.csv"},
+	}
+	for _, tt := range tests {
+		link, _ := testUpload(t, tt.name, tt.body, tt.headers)
+		if want := "/" + keyOf(link) + "/" + tt.want; link != want {
+			t.Errorf("upload as %q: link %s, want %s", tt.name, link, want)
+		}
+	}
+}
+
+func TestDownloadName(t *testing.T) {
+	setString(t, &defaultFilename, "")
+
+	tests := []struct {
+		item transferItem
+		want string
+	}{
+		{transferItem{S3Key: "abcde", Filename: "a.txt"}, "a.txt"},
+		{transferItem{S3Key: "abcde", Filename: "-", ContentType: "image/gif"}, "abcde.gif"},
+		{transferItem{S3Key: "abcde"}, "abcde"},
+	}
+	for _, tt := range tests {
+		if got := downloadName(&tt.item); got != tt.want {
+			t.Errorf("downloadName(%+v) = %q, want %q", tt.item, got, tt.want)
+		}
+	}
+}
+
+// useAllowedExtensions sets ALLOWED_EXTENSIONS for the length of the test.
+func useAllowedExtensions(t *testing.T, exts ...string) {
+	old := allowedExtensions
+	allowedExtensions = map[string]bool{}
+	for _, ext := range exts {
+		allowedExtensions[ext] = true
+	}
+	t.Cleanup(func() { allowedExtensions = old })
+}
+
+func TestAllowedExtensions(t *testing.T) {
+	useTestStores(t)
+	useAllowedExtensions(t, ".pdf", ".txt")
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		body    string
+		want    int
+	}{
+		{"report.pdf", nil, "%PDF-1.4 report", http.StatusOK},
+		{"REPORT.PDF", nil, "%PDF-1.4 report", http.StatusOK},
+		{"notes.Txt", nil, "plain notes", http.StatusOK},
+		{"image.png", nil, "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR", http.StatusUnsupportedMediaType},
+		{"archive.tar.gz", nil, "\x1f\x8b\x08\x00", http.StatusUnsupportedMediaType},
+		{"noext", nil, "plain", http.StatusUnsupportedMediaType},
+		{"fake.pdf", nil, "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR", http.StatusUnsupportedMediaType},
+		{"-", nil, "plain notes", http.StatusOK},
+		{"-", map[string]string{"Content-Type": "image/png"}, "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR", http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		resp := serve(t, testRequest("PUT", "/"+tt.name, tt.headers, tt.body))
+		if resp.StatusCode != tt.want {
+			t.Errorf("upload %s: %d, want %d", tt.name, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+func TestMislabeled(t *testing.T) {
+	png := "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+	tests := []struct {
+		name, body string
+		want       bool
+	}{
+		{"a.png", png, false},
+		{"a.pdf", png, true},
+		{"a.pdf", "%PDF-1.4", false},
+		{"a.pdf", "just text", false},
+		{"a.unknownext", png, false},
+	}
+	for _, tt := range tests {
+		if got := mislabeled(tt.name, []byte(tt.body)); got != tt.want {
+			t.Errorf("mislabeled(%q, %q) = %v", tt.name, tt.body, got)
+		}
+	}
+}
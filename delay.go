@@ -0,0 +1,39 @@
+package main
+
+import (
+	"crypto/rand"
+	"math/big"
+	"time"
+)
+
+// maxNotFoundDelay bounds the configured delay so a misconfiguration cannot
+// make dead links noticeably slow for legitimate users.
+const maxNotFoundDelay = time.Second
+
+// notFoundDelay picks a random delay in [notFoundDelayMin, notFoundDelayMax]
+// to blur the timing difference between missing, gone and existing keys.
+func notFoundDelay() time.Duration {
+	lo, hi := notFoundDelayMin, notFoundDelayMax
+	if hi > maxNotFoundDelay {
+		hi = maxNotFoundDelay
+	}
+	if lo > hi {
+		lo = hi
+	}
+	if hi <= 0 {
+		return 0
+	}
+	return lo + randDuration(hi-lo)
+}
+
+// randDuration returns a uniformly random duration in [0, max].
+func randDuration(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(max)+1))
+	if err != nil {
+		return max
+	}
+	return time.Duration(n.Int64())
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNotFoundDelayRange(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		min, max time.Duration
+		lo, hi   time.Duration
+	}{
+		{0, 0, 0, 0},
+		{10 * ms, 0, 0, 0},
+		{10 * ms, 50 * ms, 10 * ms, 50 * ms},
+		{0, 50 * ms, 0, 50 * ms},
+		{80 * ms, 20 * ms, 20 * ms, 20 * ms},
+		{0, time.Hour, 0, maxNotFoundDelay},
+		{2 * time.Hour, time.Hour, maxNotFoundDelay, maxNotFoundDelay},
+	}
+	for _, tt := range tests {
+		setDuration(t, &notFoundDelayMin, tt.min)
+		setDuration(t, &notFoundDelayMax, tt.max)
+		for i := 0; i < 100; i++ {
+			if d := notFoundDelay(); d < tt.lo || d > tt.hi {
+				t.Fatalf("[%v, %v]: delay %v outside [%v, %v]", tt.min, tt.max, d, tt.lo, tt.hi)
+			}
+		}
+	}
+}
+
+func TestRandDuration(t *testing.T) {
+	if d := randDuration(0); d != 0 {
+		t.Errorf("randDuration(0) = %v", d)
+	}
+	for i := 0; i < 100; i++ {
+		if d := randDuration(time.Second); d < 0 || d > time.Second {
+			t.Fatalf("randDuration(1s) = %v", d)
+		}
+	}
+}
+
+func TestNotFoundIsDelayed(t *testing.T) {
+	useTestStores(t)
+	setDuration(t, &notFoundDelayMin, 30*time.Millisecond)
+	setDuration(t, &notFoundDelayMax, 40*time.Millisecond)
+
+	start := time.Now()
+	resp := serve(t, testRequest("GET", "/nosuchkey/a.txt", nil, ""))
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("missing key: %d", resp.StatusCode)
+	}
+	if d := time.Since(start); d < 30*time.Millisecond {
+		t.Errorf("missing key answered after %v, want at least 30ms", d)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// maxBulkDelete bounds the entries accepted by a single bulk delete.
+const maxBulkDelete = 100
+
+// results of deleting one upload
+const (
+	deleteOK           = "deleted"
+	deleteUnauthorized = "unauthorized"
+	deleteNotFound     = "not_found"
+	deleteFailed       = "error"
+)
+
+// genDeleteToken returns a new owner token and the hash stored in its place.
+func genDeleteToken() (token, hash string, err error) {
+	b := make([]byte, 16)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	token = hex.EncodeToString(b)
+	return token, hashDeleteToken(token), nil
+}
+
+func hashDeleteToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// isOwner reports whether token is the delete token of item.
+func isOwner(item *transferItem, token string) bool {
+	if token == "" || item.DeleteTokenHash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashDeleteToken(token)), []byte(item.DeleteTokenHash)) == 1
+}
+
+// deleteUpload removes the upload under key if token proves ownership.
+// Ownership is all that is checked, unlike downloads: an upload that ran
+// out of downloads, was disabled or expired can still be deleted by its
+// owner to free the storage right away.
+func deleteUpload(ctx context.Context, key, token string) (string, error) {
+	item, err := lookup(ctx, key)
+	if err == errNotFound {
+		return deleteNotFound, nil
+	}
+	if err != nil {
+		return deleteFailed, err
+	}
+	if !isOwner(item, token) {
+		return deleteUnauthorized, nil
+	}
+
+	// under ROTATE_ON_ACCESS the owner's key leads to the current one
+	current, keys, err := rotatedChain(ctx, item)
+	if err != nil {
+		return deleteFailed, err
+	}
+	if err = objects.Delete(ctx, current.ObjectPath(), current.VersionID); err != nil {
+		return deleteFailed, err
+	}
+	for _, k := range keys {
+		if err = meta.Delete(ctx, k); err != nil {
+			return deleteFailed, err
+		}
+	}
+	if item.Slug {
+		if err = releaseSlug(ctx, item); err != nil {
+			return deleteFailed, err
+		}
+	}
+	return deleteOK, nil
+}
+
+// requestToken is the delete token presented as ?token= or in
+// X-Delete-Token.
+func requestToken(req events.APIGatewayProxyRequest) string {
+	if token := req.QueryStringParameters["token"]; token != "" {
+		return token
+	}
+	return header(req, "X-Delete-Token")
+}
+
+// del handles DELETE /{key}[/{filename}] with the token given as ?token= or
+// in X-Delete-Token.
+func del(ctx context.Context, req events.APIGatewayProxyRequest) (resp events.APIGatewayProxyResponse, err error) {
+	key := strings.SplitN(req.PathParameters["proxy"], "/", 2)[0]
+	if key == "" {
+		resp.StatusCode = http.StatusNotFound
+		return
+	}
+
+	result, err := deleteUpload(ctx, key, requestToken(req))
+	switch result {
+	case deleteOK:
+		resp.StatusCode = http.StatusNoContent
+	case deleteUnauthorized:
+		resp.StatusCode = http.StatusForbidden
+	case deleteNotFound:
+		resp.StatusCode = http.StatusNotFound
+	default:
+		resp.StatusCode = http.StatusInternalServerError
+	}
+	return
+}
+
+type bulkDeleteEntry struct {
+	Key         string `json:"key"`
+	DeleteToken string `json:"delete_token"`
+}
+
+type bulkDeleteResult struct {
+	Key    string `json:"key"`
+	Result string `json:"result"`
+}
+
+// bulkDelete handles POST /delete with a JSON list of keys and their delete
+// tokens, answering with the outcome for each key in the same order.
+func bulkDelete(ctx context.Context, req events.APIGatewayProxyRequest) (resp events.APIGatewayProxyResponse, err error) {
+	body, err := requestBody(req)
+	if err != nil {
+		resp.StatusCode = http.StatusBadRequest
+		err = nil
+		return
+	}
+
+	var entries []bulkDeleteEntry
+	if json.Unmarshal(body, &entries) != nil || len(entries) > maxBulkDelete {
+		resp.StatusCode = http.StatusBadRequest
+		return
+	}
+
+	var (
+		results = make([]bulkDeleteResult, len(entries))
+		sem     = make(chan struct{}, bulkDeleteConcurrency)
+		wg      sync.WaitGroup
+	)
+	for i, e := range entries {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, e bulkDeleteEntry) {
+			defer func() { <-sem; wg.Done() }()
+
+			result, derr := deleteUpload(ctx, e.Key, e.DeleteToken)
+			if derr != nil {
+				result = deleteFailed
+			}
+			results[i] = bulkDeleteResult{Key: e.Key, Result: result}
+		}(i, e)
+	}
+	wg.Wait()
+
+	b, err := json.Marshal(results)
+	if err != nil {
+		resp.StatusCode = http.StatusInternalServerError
+		return
+	}
+
+	resp.StatusCode = http.StatusOK
+	resp.Headers = map[string]string{
+		"Content-Type": "application/json",
+	}
+	resp.Body = string(b)
+	return
+}
+
+// dropOrphan removes the record of an upload whose object is gone, as if
+// it had been deleted. Failures are only logged: the download fails either
+// way.
+func dropOrphan(ctx context.Context, item *transferItem) {
+	log.Printf("object of %s is missing, dropping the record", item.S3Key)
+	if err := meta.Delete(ctx, item.S3Key); err != nil {
+		log.Printf("drop %s: %v", item.S3Key, err)
+		return
+	}
+	if item.Slug {
+		if err := releaseSlug(ctx, item); err != nil {
+			log.Printf("release slug %s: %v", item.S3Key, err)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestBulkDeleteMixed(t *testing.T) {
+	st, _ := useTestStores(t)
+	setInt(t, &bulkDeleteConcurrency, 2)
+
+	var good []bulkDeleteEntry
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		link, token := testUpload(t, name, "hello", nil)
+		good = append(good, bulkDeleteEntry{Key: keyOf(link), DeleteToken: token})
+	}
+
+	tests := []struct {
+		entry bulkDeleteEntry
+		want  string
+	}{
+		{good[0], deleteOK},
+		{bulkDeleteEntry{Key: good[1].Key, DeleteToken: "wrong"}, deleteUnauthorized},
+		{bulkDeleteEntry{Key: good[2].Key}, deleteUnauthorized},
+		{bulkDeleteEntry{Key: "fffff", DeleteToken: good[0].DeleteToken}, deleteNotFound},
+		{good[2], deleteOK},
+	}
+	var entries []bulkDeleteEntry
+	for _, tt := range tests {
+		entries = append(entries, tt.entry)
+	}
+	body, _ := json.Marshal(entries)
+
+	resp := serve(t, testRequest("POST", "/delete", nil, string(body)))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("bulk delete: %d", resp.StatusCode)
+	}
+	var results []bulkDeleteResult
+	if err := json.Unmarshal([]byte(resp.Body), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(tests) {
+		t.Fatalf("%d results for %d entries", len(results), len(tests))
+	}
+	for i, tt := range tests {
+		if results[i] != (bulkDeleteResult{Key: tt.entry.Key, Result: tt.want}) {
+			t.Errorf("entry %d: %+v, want %s", i, results[i], tt.want)
+		}
+	}
+
+	if _, ok := st.items[good[1].Key]; !ok {
+		t.Error("unauthorized entry was deleted")
+	}
+}
+
+func TestBulkDeleteBadRequest(t *testing.T) {
+	useTestStores(t)
+	tooMany, _ := json.Marshal(make([]bulkDeleteEntry, maxBulkDelete+1))
+
+	for _, body := range []string{"", "{}", `[{"key": 1}]`, string(tooMany)} {
+		if resp := serve(t, testRequest("POST", "/delete", nil, body)); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("body of %d bytes: %d, want 400", len(body), resp.StatusCode)
+		}
+	}
+}
+
+func TestOrphanedRecord(t *testing.T) {
+	tests := []struct {
+		name   string
+		verify bool
+		slug   string
+		want   int
+		kept   bool
+	}{
+		{"unchecked", false, "", http.StatusFound, true},
+		{"checked", true, "", http.StatusGone, false},
+		{"checked slug", true, "my-slug", http.StatusGone, false},
+	}
+	for _, tt := range tests {
+		st, fs := useTestStores(t)
+		setBool(t, &verifyObject, tt.verify)
+		setBool(t, &vanitySlugs, true)
+		setDuration(t, &slugCooldown, 0)
+
+		var headers map[string]string
+		if tt.slug != "" {
+			headers = map[string]string{"X-Slug": tt.slug}
+		}
+		link, _ := testUpload(t, "a.txt", "hello", headers)
+		key := keyOf(link)
+		if err := os.Remove(fs.path(key)); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp := serve(t, testRequest("GET", link, nil, "")); resp.StatusCode != tt.want {
+			t.Errorf("%s: download: %d, want %d", tt.name, resp.StatusCode, tt.want)
+		}
+		if _, ok := st.items[key]; ok != tt.kept {
+			t.Errorf("%s: record kept %v, want %v", tt.name, ok, tt.kept)
+		}
+		if tt.slug != "" {
+			if _, held := st.items[slugHoldKey(tt.slug)]; held {
+				t.Errorf("%s: slug still held", tt.name)
+			}
+		}
+	}
+}
+
+func TestVerifiedDownload(t *testing.T) {
+	useTestStores(t)
+	setBool(t, &verifyObject, true)
+
+	link, _ := testUpload(t, "a.txt", "hello", nil)
+	if resp := serve(t, testRequest("GET", link, nil, "")); resp.StatusCode != http.StatusFound {
+		t.Errorf("download of a present object: %d", resp.StatusCode)
+	}
+}
+
+func TestDeleteExhausted(t *testing.T) {
+	tests := []struct {
+		name    string
+		state   func(st *memoryStore, key string)
+		token   bool
+		want    int
+		dropped bool
+	}{
+		{"exhausted, owner", nil, true, http.StatusNoContent, true},
+		{"exhausted, no token", nil, false, http.StatusForbidden, false},
+		{"exhausted and disabled, owner", func(st *memoryStore, key string) {
+			st.items[key]["disabled"] = &dynamodb.AttributeValue{BOOL: aws.Bool(true)}
+		}, true, http.StatusNoContent, true},
+		{"expired, owner", func(st *memoryStore, key string) {
+			st.items[key]["expire_at"] = numberAttr(time.Now().Add(-time.Minute).Unix())
+		}, true, http.StatusNoContent, true},
+		{"expired, no token", func(st *memoryStore, key string) {
+			st.items[key]["expire_at"] = numberAttr(time.Now().Add(-time.Minute).Unix())
+		}, false, http.StatusForbidden, false},
+	}
+	for _, tt := range tests {
+		st, fs := useTestStores(t)
+		setInt(t, &maxDownloads, 1)
+
+		link, token := testUpload(t, "a.txt", "hello", nil)
+		key := keyOf(link)
+		serve(t, testRequest("GET", link, nil, ""))
+		if resp := serve(t, testRequest("GET", link, nil, "")); resp.StatusCode != http.StatusGone {
+			t.Fatalf("%s: download past the limit: %d", tt.name, resp.StatusCode)
+		}
+		if tt.state != nil {
+			tt.state(st, key)
+		}
+
+		headers := map[string]string{}
+		if tt.token {
+			headers["X-Delete-Token"] = token
+		}
+		if resp := serve(t, testRequest("DELETE", link, headers, "")); resp.StatusCode != tt.want {
+			t.Errorf("%s: delete: %d, want %d", tt.name, resp.StatusCode, tt.want)
+		}
+		_, kept := st.items[key]
+		if _, err := os.Stat(fs.path(key)); os.IsNotExist(err) != tt.dropped || kept == tt.dropped {
+			t.Errorf("%s: record kept %v, object gone %v", tt.name, kept, os.IsNotExist(err))
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// devFilesPrefix is where the dev server exposes an fs storage directory.
+const devFilesPrefix = "/_files/"
+
+// serveDev runs the handler behind a plain HTTP server on addr, translating
+// requests into the API Gateway proxy events Lambda would receive.
+func serveDev(addr string) error {
+	mux := http.NewServeMux()
+
+	if st, ok := objects.(*fsStorage); ok {
+		mux.Handle(devFilesPrefix, http.StripPrefix(devFilesPrefix, http.FileServer(http.Dir(st.dir))))
+	}
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		req := events.APIGatewayProxyRequest{
+			Path:                  r.URL.Path,
+			HTTPMethod:            r.Method,
+			Headers:               map[string]string{},
+			QueryStringParameters: map[string]string{},
+			PathParameters: map[string]string{
+				"proxy": strings.TrimPrefix(r.URL.Path, "/"),
+			},
+			Body:            base64.StdEncoding.EncodeToString(b),
+			IsBase64Encoded: true,
+		}
+		req.RequestContext.HTTPMethod = r.Method
+		req.RequestContext.Identity.SourceIP = strings.Split(r.RemoteAddr, ":")[0]
+		for k := range r.Header {
+			req.Headers[k] = r.Header.Get(k)
+		}
+		// net/http moves Host out of the header map, API Gateway does not
+		req.Headers["Host"] = r.Host
+		for k := range r.URL.Query() {
+			req.QueryStringParameters[k] = r.URL.Query().Get(k)
+		}
+
+		resp, err := handleRequest(context.Background(), req)
+		if err != nil {
+			log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+		}
+
+		for k, v := range resp.Headers {
+			w.Header().Set(k, v)
+		}
+		w.WriteHeader(resp.StatusCode)
+
+		body := []byte(resp.Body)
+		if resp.IsBase64Encoded {
+			body, _ = base64.StdEncoding.DecodeString(resp.Body)
+		}
+		w.Write(body)
+	})
+
+	log.Printf("dev server listening on %s", addr)
+	return http.ListenAndServe(addr, mux)
+}
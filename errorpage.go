@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+var errorPageTemplate = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Status}}</title>
+</head>
+<body>
+<h1>{{.Status}}</h1>
+<p>{{.Message}}</p>
+{{if .Link}}<p><a href="{{.Link}}">{{.Link}}</a></p>
+{{end}}</body>
+</html>
+`))
+
+// errorMessages are shown on the page when ERROR_PAGE_MESSAGE is not set.
+var errorMessages = map[int]string{
+	http.StatusNotFound: "There is no file at this link.",
+	http.StatusGone:     "This link has expired or run out of downloads.",
+}
+
+// acceptsHTML reports whether the client is a browser, or at least asked
+// for HTML.
+func acceptsHTML(req events.APIGatewayProxyRequest) bool {
+	return strings.Contains(header(req, "Accept"), "text/html")
+}
+
+// errorPage replaces the body of 404 and 410 answers to GET requests from
+// browsers with the page configured by ERROR_PAGE_MESSAGE and
+// ERROR_PAGE_LINK. API clients keep the plain answer.
+func errorPage(req events.APIGatewayProxyRequest, resp *events.APIGatewayProxyResponse) {
+	if !errorPages || req.RequestContext.HTTPMethod != http.MethodGet || !acceptsHTML(req) {
+		return
+	}
+	if resp.StatusCode != http.StatusNotFound && resp.StatusCode != http.StatusGone {
+		return
+	}
+
+	message := errorPageMessage
+	if message == "" {
+		message = errorMessages[resp.StatusCode]
+	}
+
+	var buf bytes.Buffer
+	err := errorPageTemplate.Execute(&buf, struct {
+		Status  string
+		Message string
+		Link    string
+	}{http.StatusText(resp.StatusCode), message, errorPageLink})
+	if err != nil {
+		log.Printf("error page: %v", err)
+		return
+	}
+
+	if resp.Headers == nil {
+		resp.Headers = map[string]string{}
+	}
+	resp.Headers["Content-Type"] = "text/html; charset=utf-8"
+	resp.Body = buf.String()
+}
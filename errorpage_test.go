@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestErrorPages(t *testing.T) {
+	useTestStores(t)
+	setBool(t, &errorPages, true)
+	setInt(t, &maxDownloads, 1)
+
+	link, _ := testUpload(t, "a.txt", "hello", nil)
+	serve(t, testRequest("GET", link, nil, ""))
+
+	const browser = "text/html,application/xhtml+xml,*/*;q=0.8"
+	tests := []struct {
+		method, path, accept string
+		status               int
+		html                 bool
+	}{
+		{"GET", "/nosuchkey/a.txt", browser, http.StatusNotFound, true},
+		{"GET", link, browser, http.StatusGone, true},
+		{"GET", "/nosuchkey/a.txt", "*/*", http.StatusNotFound, false},
+		{"GET", "/nosuchkey/a.txt", "application/json", http.StatusNotFound, false},
+		{"GET", link, "", http.StatusGone, false},
+		{"HEAD", link, browser, http.StatusGone, false},
+		{"DELETE", "/nosuchkey", browser, http.StatusNotFound, false},
+	}
+	for _, tt := range tests {
+		req := testRequest(tt.method, tt.path, map[string]string{"Accept": tt.accept}, "")
+		// API Gateway sets the method on the request context, as route reads it
+		req.HTTPMethod = ""
+		resp := serve(t, req)
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s %s: %d, want %d", tt.method, tt.path, resp.StatusCode, tt.status)
+		}
+		html := strings.HasPrefix(resp.Headers["Content-Type"], "text/html")
+		if html != tt.html || html != strings.HasPrefix(resp.Body, "<!DOCTYPE html>") {
+			t.Errorf("%s %s accepting %q: %q, %q", tt.method, tt.path, tt.accept, resp.Headers["Content-Type"], resp.Body)
+		}
+		if html && !strings.Contains(resp.Body, errorMessages[tt.status]) {
+			t.Errorf("%s %s: page lacks the default message: %s", tt.method, tt.path, resp.Body)
+		}
+	}
+}
+
+func TestErrorPageConfigured(t *testing.T) {
+	useTestStores(t)
+	setBool(t, &errorPages, true)
+	setString(t, &errorPageMessage, "Ask the sender for a <new> link.")
+	setString(t, &errorPageLink, "https://transfer.test/")
+
+	resp := serve(t, testRequest("GET", "/nosuchkey/a.txt", map[string]string{"Accept": "text/html"}, ""))
+	for _, want := range []string{"<title>Not Found</title>", "Ask the sender for a &lt;new&gt; link.", `<a href="https://transfer.test/">`} {
+		if !strings.Contains(resp.Body, want) {
+			t.Errorf("page lacks %s: %s", want, resp.Body)
+		}
+	}
+
+	setBool(t, &errorPages, false)
+	resp = serve(t, testRequest("GET", "/nosuchkey/a.txt", map[string]string{"Accept": "text/html"}, ""))
+	if strings.Contains(resp.Body, "<html>") {
+		t.Errorf("page served without ERROR_PAGES: %s", resp.Body)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+var (
+	errBadExpiry     = errors.New("invalid expiry")
+	errExpiryTooLong = errors.New("expiry over the maximum")
+)
+
+// MAX_EXPIRE_MODE values: cut a longer X-Expire-Hours down to
+// MAX_EXPIRE_HOURS, or refuse the upload.
+const (
+	expireClamp  = "clamp"
+	expireReject = "reject"
+)
+
+// maxDurationHours is the most hours a time.Duration holds.
+const maxDurationHours = int(math.MaxInt64 / int64(time.Hour))
+
+// expiryRule caps the lifetime of uploads up to MaxSize bytes. A rule with a
+// zero MaxSize matches uploads of any size.
+type expiryRule struct {
+	MaxSize  int64 `json:"max_size"`
+	MaxHours int   `json:"max_hours"`
+}
+
+// parseExpiryPolicy reads rules such as
+//
+//	[{"max_size": 104857600, "max_hours": 168}, {"max_hours": 24}]
+//
+// and orders them from the smallest size range to the catch-all.
+func parseExpiryPolicy(s string) ([]expiryRule, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var rules []expiryRule
+	if err := json.Unmarshal([]byte(s), &rules); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].MaxSize == 0 || rules[j].MaxSize == 0 {
+			return rules[j].MaxSize == 0 && rules[i].MaxSize != 0
+		}
+		return rules[i].MaxSize < rules[j].MaxSize
+	})
+	return rules, nil
+}
+
+// maxExpireFor returns the longest lifetime allowed for an upload of size
+// bytes, or zero when no rule applies.
+func maxExpireFor(size int64) time.Duration {
+	for _, rule := range expiryPolicy {
+		if rule.MaxSize == 0 || size <= rule.MaxSize {
+			return time.Duration(rule.MaxHours) * time.Hour
+		}
+	}
+	return 0
+}
+
+// uploadExpiry resolves the lifetime of an upload from the X-Expire-Hours
+// header, falling back to the default, and clamps it to the size policy.
+// Requests over MAX_EXPIRE_HOURS, 30 days unless configured, are clamped or
+// refused per MAX_EXPIRE_MODE. A zero lifetime means the upload never
+// expires, which only ALLOW_PERMANENT lets a client ask for, with "0" or
+// "never".
+func uploadExpiry(req events.APIGatewayProxyRequest, size int64) (time.Duration, error) {
+	d := defaultExpire
+
+	if v := header(req, "X-Expire-Hours"); v != "" {
+		h, err := strconv.Atoi(v)
+		switch {
+		case allowPermanent && (v == "0" || v == "never"):
+			h = 0
+		case err != nil || h <= 0 || h > maxDurationHours:
+			return 0, errBadExpiry
+		case h > maxExpireHours:
+			if maxExpireMode == expireReject {
+				return 0, errExpiryTooLong
+			}
+			h = maxExpireHours
+		}
+		d = time.Duration(h) * time.Hour
+	}
+	// the default lifetime obeys the ceiling as well
+	if ceiling := time.Duration(maxExpireHours) * time.Hour; d > ceiling {
+		d = ceiling
+	}
+
+	if limit := maxExpireFor(size); limit > 0 && (d == 0 || d > limit) {
+		d = limit
+	}
+	return d, nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useExpiryPolicy sets EXPIRY_POLICY for the length of the test.
+func useExpiryPolicy(t *testing.T, s string) {
+	t.Helper()
+
+	rules, err := parseExpiryPolicy(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := expiryPolicy
+	expiryPolicy = rules
+	t.Cleanup(func() { expiryPolicy = old })
+}
+
+func TestParseExpiryPolicyOrder(t *testing.T) {
+	rules, err := parseExpiryPolicy(`[{"max_hours": 24}, {"max_size": 1000, "max_hours": 168}, {"max_size": 10, "max_hours": 720}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int64{10, 1000, 0}
+	for i, rule := range rules {
+		if rule.MaxSize != want[i] {
+			t.Fatalf("rules ordered %+v, want sizes %v", rules, want)
+		}
+	}
+
+	if _, err := parseExpiryPolicy(`{"max_hours": 1}`); err == nil {
+		t.Error("non-list policy accepted")
+	}
+	if rules, err := parseExpiryPolicy(""); err != nil || rules != nil {
+		t.Errorf("empty policy: %v, %v", rules, err)
+	}
+}
+
+func TestUploadExpiryBySize(t *testing.T) {
+	useExpiryPolicy(t, `[{"max_size": 1000, "max_hours": 168}, {"max_size": 1000000, "max_hours": 48}, {"max_hours": 6}]`)
+	setInt(t, &maxExpireHours, 1000)
+
+	tests := []struct {
+		size  int64
+		hours string
+		want  time.Duration
+	}{
+		{100, "", defaultExpire},
+		{100, "100", 100 * time.Hour},
+		{100, "500", 168 * time.Hour},
+		{1000, "500", 168 * time.Hour},
+		{1001, "500", 48 * time.Hour},
+		{5000, "", 48 * time.Hour},
+		{2000000, "", 6 * time.Hour},
+		{2000000, "1", time.Hour},
+	}
+	for _, tt := range tests {
+		headers := map[string]string{}
+		if tt.hours != "" {
+			headers["X-Expire-Hours"] = tt.hours
+		}
+		got, err := uploadExpiry(testRequest("PUT", "/f", headers, ""), tt.size)
+		if err != nil || got != tt.want {
+			t.Errorf("size %d, %q hours: %v, %v; want %v", tt.size, tt.hours, got, err, tt.want)
+		}
+	}
+}
+
+func TestUploadExpiryInvalid(t *testing.T) {
+	for _, v := range []string{"0", "-1", "soon", "99999999999"} {
+		_, err := uploadExpiry(testRequest("PUT", "/f", map[string]string{"X-Expire-Hours": v}, ""), 1)
+		if err != errBadExpiry {
+			t.Errorf("%q: %v, want errBadExpiry", v, err)
+		}
+	}
+}
+
+func TestUploadExpiryCeiling(t *testing.T) {
+	tests := []struct {
+		mode      string
+		permanent bool
+		hours     string
+		want      time.Duration
+		err       error
+	}{
+		{expireClamp, false, "", defaultExpire, nil},
+		{expireClamp, false, "720", 720 * time.Hour, nil},
+		{expireClamp, false, "721", 720 * time.Hour, nil},
+		{expireClamp, false, "2000000", 720 * time.Hour, nil},
+		{expireReject, false, "720", 720 * time.Hour, nil},
+		{expireReject, false, "721", 0, errExpiryTooLong},
+		{expireClamp, false, "0", 0, errBadExpiry},
+		{expireClamp, false, "never", 0, errBadExpiry},
+		{expireClamp, true, "0", 0, nil},
+		{expireReject, true, "never", 0, nil},
+		{expireReject, true, "721", 0, errExpiryTooLong},
+	}
+	for _, tt := range tests {
+		setInt(t, &maxExpireHours, defaultMaxExpireHours)
+		setString(t, &maxExpireMode, tt.mode)
+		setBool(t, &allowPermanent, tt.permanent)
+
+		got, err := uploadExpiry(testRequest("PUT", "/f", map[string]string{"X-Expire-Hours": tt.hours}, ""), 1)
+		if err != tt.err || got != tt.want {
+			t.Errorf("%s, permanent %v, %q hours: %v, %v; want %v, %v", tt.mode, tt.permanent, tt.hours, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestUploadExpiryCeilingBelowDefault(t *testing.T) {
+	setInt(t, &maxExpireHours, 1)
+	if got, err := uploadExpiry(testRequest("PUT", "/f", nil, ""), 1); err != nil || got != time.Hour {
+		t.Errorf("default lifetime under a 1 hour ceiling: %v, %v", got, err)
+	}
+}
+
+func TestPermanentUploadObeysSizePolicy(t *testing.T) {
+	useExpiryPolicy(t, `[{"max_hours": 6}]`)
+	setBool(t, &allowPermanent, true)
+
+	if got, err := uploadExpiry(testRequest("PUT", "/f", map[string]string{"X-Expire-Hours": "never"}, ""), 1); err != nil || got != 6*time.Hour {
+		t.Errorf("permanent upload under a 6 hour policy: %v, %v", got, err)
+	}
+}
+
+func TestPermanentUpload(t *testing.T) {
+	tests := []struct {
+		permanent bool
+		mode      string
+		hours     string
+		status    int
+		expires   bool
+	}{
+		{false, expireClamp, "never", http.StatusBadRequest, false},
+		{true, expireClamp, "never", http.StatusOK, false},
+		{true, expireClamp, "100000", http.StatusOK, true},
+		{true, expireReject, "100000", http.StatusBadRequest, false},
+	}
+	for _, tt := range tests {
+		st, _ := useTestStores(t)
+		setBool(t, &allowPermanent, tt.permanent)
+		setString(t, &maxExpireMode, tt.mode)
+
+		resp := serve(t, testRequest("PUT", "/a.txt", map[string]string{"X-Expire-Hours": tt.hours}, "hello"))
+		if resp.StatusCode != tt.status {
+			t.Errorf("permanent %v, %s, %q hours: %d, want %d", tt.permanent, tt.mode, tt.hours, resp.StatusCode, tt.status)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			continue
+		}
+		_, expires := st.items[keyOf(strings.TrimPrefix(resp.Body, testDomain))]["expire_at"]
+		if expires != tt.expires {
+			t.Errorf("permanent %v, %q hours: stored expire_at %v, want %v", tt.permanent, tt.hours, expires, tt.expires)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// itemStatus tells apart uploads that can still be downloaded from those
+// that are gone: expired, out of downloads, disabled or rotated away.
+func itemStatus(item *transferItem, now time.Time) int {
+	if !item.Public && item.Times >= item.DownloadLimit() {
+		return http.StatusGone
+	}
+	return liveStatus(item, now)
+}
+
+// liveStatus is itemStatus for a fetch whose download was already counted,
+// by the download page it came from: only expired, disabled or rotated
+// uploads are gone.
+func liveStatus(item *transferItem, now time.Time) int {
+	switch {
+	case item.Disabled, item.RotatedTo != "":
+		return http.StatusGone
+	case item.ExpireAt > 0 && item.ExpireAt <= now.Unix():
+		return http.StatusGone
+	}
+	return http.StatusOK
+}
+
+// knownSize returns the size of item, unless it was uploaded before sizes
+// were recorded. Only those records lack the hash recorded alongside.
+func knownSize(item *transferItem) (int64, bool) {
+	return item.Size, item.Size > 0 || item.SHA256 != ""
+}
+
+// downloadHeaders describes how long item can still be downloaded, and how
+// often when showCount is set.
+func downloadHeaders(item *transferItem, headers map[string]string, showCount bool) map[string]string {
+	if headers == nil {
+		headers = map[string]string{}
+	}
+
+	left := item.DownloadLimit() - item.Times
+	if left < 0 {
+		left = 0
+	}
+	if showCount && !item.Public {
+		headers["X-Downloads-Remaining"] = strconv.Itoa(left)
+	}
+	if item.ExpireAt > 0 {
+		headers["X-Expire-At"] = time.Unix(item.ExpireAt, 0).UTC().Format(http.TimeFormat)
+	}
+	return headers
+}
+
+// showsCount reports whether the download counts of item may be shown to
+// the client: always, unless the uploader hid them with X-Hide-Downloads,
+// in which case only to the holder of the delete token.
+func showsCount(req events.APIGatewayProxyRequest, item *transferItem) bool {
+	return !item.HideDownloads || isOwner(item, requestToken(req))
+}
+
+// head reports the state of an upload without counting a download: 200 when
+// it can be downloaded, 410 when it expired, ran out of downloads or was
+// disabled, and 404 when there is no such upload. It answers 401 unless the
+// X-View-Password of the upload is right, and unless its X-Password is, so
+// that clients can check a password before spending a download on it.
+func head(ctx context.Context, req events.APIGatewayProxyRequest) (resp events.APIGatewayProxyResponse, err error) {
+	defer func() {
+		if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+			time.Sleep(notFoundDelay())
+		}
+	}()
+
+	s3key, _, ok := splitPath(req.PathParameters["proxy"])
+	if !ok {
+		resp.StatusCode = http.StatusNotFound
+		return
+	}
+
+	item, err := lookup(ctx, s3key)
+	if err == errNotFound {
+		resp.StatusCode = http.StatusNotFound
+		err = nil
+		return
+	}
+	if err != nil {
+		resp.StatusCode = http.StatusInternalServerError
+		return
+	}
+
+	if resp.StatusCode = viewPasswordStatus(req, item); resp.StatusCode != http.StatusOK {
+		return
+	}
+
+	resp.StatusCode = itemStatus(item, time.Now())
+	resp.Headers = downloadHeaders(item, nil, showsCount(req, item))
+	if resp.StatusCode == http.StatusOK {
+		resp.StatusCode = downloadPasswordStatus(req, item)
+	}
+	if resp.StatusCode == http.StatusOK {
+		sniffLegacyType(ctx, item)
+		if item.ContentType != "" {
+			resp.Headers["Content-Type"] = item.ContentType
+		}
+	}
+	if n, ok := knownSize(item); ok && resp.StatusCode == http.StatusOK {
+		resp.Headers["Content-Length"] = strconv.FormatInt(n, 10)
+	}
+	return
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestHeadStates(t *testing.T) {
+	past := numberAttr(time.Now().Add(-time.Minute).Unix())
+
+	tests := []struct {
+		name   string
+		attrs  map[string]*dynamodb.AttributeValue
+		status int
+		left   string
+	}{
+		{"live", nil, http.StatusOK, "2"},
+		{"one used", map[string]*dynamodb.AttributeValue{"times": numberAttr(1)}, http.StatusOK, "1"},
+		{"exhausted", map[string]*dynamodb.AttributeValue{"times": numberAttr(2)}, http.StatusGone, "0"},
+		{"expired", map[string]*dynamodb.AttributeValue{"expire_at": past}, http.StatusGone, "2"},
+		{"disabled", map[string]*dynamodb.AttributeValue{"disabled": {BOOL: aws.Bool(true)}}, http.StatusGone, "2"},
+		{"rotated", map[string]*dynamodb.AttributeValue{"rotated_to": {S: aws.String("fffff")}}, http.StatusGone, "2"},
+	}
+	for _, tt := range tests {
+		st, _ := useTestStores(t)
+		setInt(t, &maxDownloads, 2)
+
+		link, _ := testUpload(t, "a.txt", "hello", nil)
+		key := keyOf(link)
+		for k, v := range tt.attrs {
+			st.items[key][k] = v
+		}
+		before := st.items[key]["times"]
+
+		resp := serve(t, testRequest("HEAD", link, nil, ""))
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: %d, want %d", tt.name, resp.StatusCode, tt.status)
+		}
+		if resp.Headers["X-Downloads-Remaining"] != tt.left || resp.Headers["X-Expire-At"] == "" {
+			t.Errorf("%s: headers %v", tt.name, resp.Headers)
+		}
+		if (resp.Headers["Content-Length"] == "5") != (tt.status == http.StatusOK) {
+			t.Errorf("%s: Content-Length %q", tt.name, resp.Headers["Content-Length"])
+		}
+		if resp.Body != "" {
+			t.Errorf("%s: body %q", tt.name, resp.Body)
+		}
+		if st.items[key]["times"] != before {
+			t.Errorf("%s: HEAD touched the count", tt.name)
+		}
+	}
+}
+
+func TestHeadMissing(t *testing.T) {
+	useTestStores(t)
+	setDuration(t, &notFoundDelayMin, 30*time.Millisecond)
+	setDuration(t, &notFoundDelayMax, 40*time.Millisecond)
+
+	for _, target := range []string{"/nosuchkey/a.txt", "/nosuchkey"} {
+		start := time.Now()
+		resp := serve(t, testRequest("HEAD", target, nil, ""))
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: %d, want 404", target, resp.StatusCode)
+		}
+		if d := time.Since(start); d < 30*time.Millisecond {
+			t.Errorf("%s answered after %v, want at least 30ms", target, d)
+		}
+	}
+}
+
+func TestHeadGoneIsDelayed(t *testing.T) {
+	useTestStores(t)
+	setInt(t, &maxDownloads, 1)
+
+	link, _ := testUpload(t, "a.txt", "hello", nil)
+	serve(t, testRequest("GET", link, nil, ""))
+
+	setDuration(t, &notFoundDelayMin, 30*time.Millisecond)
+	setDuration(t, &notFoundDelayMax, 40*time.Millisecond)
+	start := time.Now()
+	if resp := serve(t, testRequest("HEAD", link, nil, "")); resp.StatusCode != http.StatusGone {
+		t.Fatalf("HEAD: %d, want 410", resp.StatusCode)
+	}
+	if d := time.Since(start); d < 30*time.Millisecond {
+		t.Errorf("gone answered after %v, want at least 30ms", d)
+	}
+
+	item, _ := meta.Get(context.Background(), keyOf(link))
+	if item.Times != 1 {
+		t.Errorf("counted %d downloads, want 1", item.Times)
+	}
+}
+
+func TestHiddenDownloadCounts(t *testing.T) {
+	useTestStores(t)
+	setInt(t, &maxDownloads, 2)
+
+	link, token := testUpload(t, "a.txt", "hello", map[string]string{"X-Hide-Downloads": "true"})
+	owner := map[string]string{"X-Delete-Token": token}
+
+	tests := []struct {
+		method  string
+		headers map[string]string
+		status  int
+		left    string
+	}{
+		{"HEAD", nil, http.StatusOK, ""},
+		{"HEAD", owner, http.StatusOK, "2"},
+		{"GET", nil, http.StatusFound, ""},
+		{"HEAD", owner, http.StatusOK, "1"},
+		{"GET", owner, http.StatusFound, "0"},
+		{"GET", nil, http.StatusGone, ""},
+		{"HEAD", map[string]string{"X-Delete-Token": "wrong"}, http.StatusGone, ""},
+		{"HEAD", owner, http.StatusGone, "0"},
+	}
+	for i, tt := range tests {
+		resp := serve(t, testRequest(tt.method, link, tt.headers, ""))
+		if resp.StatusCode != tt.status {
+			t.Fatalf("step %d, %s: %d, want %d", i, tt.method, resp.StatusCode, tt.status)
+		}
+		if left, shown := resp.Headers["X-Downloads-Remaining"]; left != tt.left || shown != (tt.left != "") {
+			t.Errorf("step %d, %s: remaining %q, want %q", i, tt.method, left, tt.left)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// requestHost is the host the client addressed, as told by a fronting proxy
+// in X-Forwarded-Host or else by Host.
+func requestHost(req events.APIGatewayProxyRequest) string {
+	host := header(req, "X-Forwarded-Host")
+	if host == "" {
+		host = header(req, "Host")
+	}
+	// proxies chaining X-Forwarded-Host append, the client's comes first
+	if i := strings.IndexByte(host, ','); i >= 0 {
+		host = host[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(host))
+}
+
+// allowedHost reports whether host is in ALLOWED_DOMAINS, either by name or
+// under a wildcard entry such as "*.example.com".
+func allowedHost(host string) bool {
+	if host == "" {
+		return false
+	}
+	for _, d := range allowedDomains {
+		if d == host || strings.HasPrefix(d, "*.") && strings.HasSuffix(host, d[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestDomain is the base of the urls handed out in answer to req. With
+// DOMAIN_FROM_HOST it follows the host the client used, when that host is
+// allowed, keeping the scheme of DOMAIN; otherwise it is DOMAIN.
+func requestDomain(req events.APIGatewayProxyRequest) string {
+	if !domainFromHost {
+		return domain
+	}
+	host := requestHost(req)
+	if !allowedHost(host) {
+		return domain
+	}
+
+	scheme := "https"
+	if i := strings.Index(domain, "://"); i >= 0 {
+		scheme = domain[:i]
+	}
+	return scheme + "://" + host
+}
+
+// misdirected reports whether req must be refused under
+// REJECT_UNKNOWN_HOSTS for naming a host outside ALLOWED_DOMAINS. Only Host
+// counts here: X-Forwarded-Host is whatever the client chose to send.
+func misdirected(req events.APIGatewayProxyRequest) bool {
+	return rejectUnknownHosts && !allowedHost(strings.ToLower(header(req, "Host")))
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// useAllowedDomains sets ALLOWED_DOMAINS for the length of the test.
+func useAllowedDomains(t *testing.T, domains ...string) {
+	old := allowedDomains
+	allowedDomains = domains
+	t.Cleanup(func() { allowedDomains = old })
+}
+
+func TestAllowedHost(t *testing.T) {
+	useAllowedDomains(t, "files.example.com", "*.share.test")
+
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"files.example.com", true},
+		{"a.share.test", true},
+		{"a.b.share.test", true},
+		{"share.test", false},
+		{"evilshare.test", false},
+		{"files.example.com.evil.test", false},
+		{"example.com", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := allowedHost(tt.host); got != tt.want {
+			t.Errorf("allowedHost(%q) = %v", tt.host, got)
+		}
+	}
+}
+
+func TestRequestHost(t *testing.T) {
+	tests := []struct {
+		headers map[string]string
+		want    string
+	}{
+		{map[string]string{"Host": "Files.Example.com"}, "files.example.com"},
+		{map[string]string{"Host": "a.test", "X-Forwarded-Host": "b.test"}, "b.test"},
+		{map[string]string{"X-Forwarded-Host": "client.test, proxy.test"}, "client.test"},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		if got := requestHost(testRequest("GET", "/", tt.headers, "")); got != tt.want {
+			t.Errorf("requestHost(%v) = %q, want %q", tt.headers, got, tt.want)
+		}
+	}
+}
+
+func TestHostDerivedURLs(t *testing.T) {
+	useTestStores(t)
+	useAllowedDomains(t, "files.example.com", "*.share.test")
+
+	tests := []struct {
+		fromHost bool
+		headers  map[string]string
+		want     string
+	}{
+		{true, map[string]string{"Host": "files.example.com"}, "https://files.example.com/"},
+		{true, map[string]string{"Host": "team.share.test"}, "https://team.share.test/"},
+		{true, map[string]string{"Host": "lambda.test", "X-Forwarded-Host": "team.share.test"}, "https://team.share.test/"},
+		{true, map[string]string{"Host": "evil.test"}, testDomain + "/"},
+		{true, nil, testDomain + "/"},
+		{false, map[string]string{"Host": "files.example.com"}, testDomain + "/"},
+	}
+	for _, tt := range tests {
+		setBool(t, &domainFromHost, tt.fromHost)
+		resp := serve(t, testRequest("PUT", "/a.txt", tt.headers, "hello"))
+		if !strings.HasPrefix(resp.Body, tt.want) {
+			t.Errorf("from host %v, %v: %q, want under %s", tt.fromHost, tt.headers, resp.Body, tt.want)
+		}
+	}
+}
+
+func TestRejectUnknownHosts(t *testing.T) {
+	useAllowedDomains(t, "files.example.com", "*.share.test")
+
+	tests := []struct {
+		reject  bool
+		headers map[string]string
+		want    int
+	}{
+		{true, map[string]string{"Host": "files.example.com"}, http.StatusOK},
+		{true, map[string]string{"Host": "FILES.example.com"}, http.StatusOK},
+		{true, map[string]string{"Host": "team.share.test"}, http.StatusOK},
+		{true, map[string]string{"Host": "evil.test"}, http.StatusMisdirectedRequest},
+		{true, map[string]string{"Host": "evil.test", "X-Forwarded-Host": "files.example.com"}, http.StatusMisdirectedRequest},
+		{true, nil, http.StatusMisdirectedRequest},
+		{false, map[string]string{"Host": "evil.test"}, http.StatusOK},
+		{false, nil, http.StatusOK},
+	}
+	for _, tt := range tests {
+		useTestStores(t)
+		setBool(t, &rejectUnknownHosts, false)
+		link, _ := testUpload(t, "a.txt", "hello", nil)
+		setBool(t, &rejectUnknownHosts, tt.reject)
+
+		for _, method := range []string{"PUT", "GET", "HEAD", "DELETE"} {
+			target := link
+			if method == "PUT" {
+				target = "/b.txt"
+			}
+			resp := serve(t, testRequest(method, target, tt.headers, "hello"))
+			misdirected := resp.StatusCode == http.StatusMisdirectedRequest
+			if misdirected != (tt.want == http.StatusMisdirectedRequest) {
+				t.Errorf("reject %v, %s %v: %d, want %d", tt.reject, method, tt.headers, resp.StatusCode, tt.want)
+			}
+			if method == "PUT" && resp.StatusCode != tt.want {
+				t.Errorf("reject %v, upload %v: %d, want %d", tt.reject, tt.headers, resp.StatusCode, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// fileInfo is the public view of a transfer record. It leaves out whatever
+// identifies the uploader or grants control over the upload.
+type fileInfo struct {
+	Filename           string `json:"filename"`
+	Size               int64  `json:"size,omitempty"`
+	ContentType        string `json:"content_type,omitempty"`
+	CreatedAt          int64  `json:"created_at,omitempty"`
+	ExpireAt           int64  `json:"expire_at"`
+	Downloads          *int   `json:"downloads,omitempty"`
+	DownloadsRemaining *int   `json:"downloads_remaining,omitempty"`
+	Disabled           bool   `json:"disabled"`
+}
+
+// newFileInfo describes item, with the download counts only when showCount
+// is set.
+func newFileInfo(item *transferItem, showCount bool) fileInfo {
+	fi := fileInfo{
+		Filename:    item.Filename,
+		Size:        item.Size,
+		ContentType: item.ContentType,
+		CreatedAt:   item.CreatedAt,
+		ExpireAt:    item.ExpireAt,
+		Disabled:    item.Disabled,
+	}
+
+	if showCount {
+		times, left := item.Times, item.DownloadLimit()-item.Times
+		if left < 0 {
+			left = 0
+		}
+		fi.Downloads, fi.DownloadsRemaining = &times, &left
+	}
+	return fi
+}
+
+// info handles GET /info/{key}/{filename}, describing the upload without
+// counting a download.
+func info(ctx context.Context, req events.APIGatewayProxyRequest) (resp events.APIGatewayProxyResponse, err error) {
+	defer func() {
+		if resp.StatusCode == http.StatusNotFound {
+			time.Sleep(notFoundDelay())
+		}
+	}()
+
+	s3key, _, ok := splitPath(strings.TrimPrefix(req.PathParameters["proxy"], "info/"))
+	if !ok {
+		resp.StatusCode = http.StatusNotFound
+		return
+	}
+
+	item, err := lookup(ctx, s3key)
+	if err == errNotFound {
+		resp.StatusCode = http.StatusNotFound
+		err = nil
+		return
+	}
+	if err != nil {
+		resp.StatusCode = http.StatusInternalServerError
+		return
+	}
+
+	if resp.StatusCode = viewPasswordStatus(req, item); resp.StatusCode != http.StatusOK {
+		resp.Body = "password required\n"
+		return
+	}
+
+	b, err := json.Marshal(newFileInfo(item, showsCount(req, item)))
+	if err != nil {
+		resp.StatusCode = http.StatusInternalServerError
+		return
+	}
+
+	resp.StatusCode = http.StatusOK
+	resp.Headers = map[string]string{
+		"Content-Type": "application/json",
+	}
+	resp.Body = string(b)
+	return
+}
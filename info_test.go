@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInfoCountsNothing(t *testing.T) {
+	useTestStores(t)
+	setInt(t, &maxDownloads, 3)
+
+	link, _ := testUpload(t, "a.txt", "hello", map[string]string{"Content-Type": "text/plain"})
+	key := keyOf(link)
+
+	for _, target := range []string{"/info" + link, "/info" + link, "/info/" + key + "/other.txt"} {
+		resp := serve(t, testRequest("GET", target, nil, ""))
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: %d", target, resp.StatusCode)
+		}
+
+		var fi fileInfo
+		if err := json.Unmarshal([]byte(resp.Body), &fi); err != nil {
+			t.Fatal(err)
+		}
+		if fi.Filename != "a.txt" || fi.Size != 5 || fi.ExpireAt == 0 || fi.Disabled {
+			t.Errorf("%s: %+v", target, fi)
+		}
+		if fi.Downloads == nil || *fi.Downloads != 0 || *fi.DownloadsRemaining != 3 {
+			t.Errorf("%s: counts %v, %v", target, fi.Downloads, fi.DownloadsRemaining)
+		}
+	}
+
+	item, err := meta.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Times != 0 {
+		t.Errorf("info counted %d downloads", item.Times)
+	}
+}
+
+func TestInfoRedacts(t *testing.T) {
+	useTestStores(t)
+
+	link, _ := testUpload(t, "a.txt", "hello", map[string]string{
+		"X-Notify-On-Download": "me@example.com",
+		"X-Password":           "pw",
+	})
+	resp := serve(t, testRequest("GET", "/info"+link, nil, ""))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("info: %d", resp.StatusCode)
+	}
+	for _, field := range []string{"ip", "uploader", "delete_token", "password", "notify", "192.0.2.1", "me@example.com"} {
+		if strings.Contains(resp.Body, field) {
+			t.Errorf("info shows %s: %s", field, resp.Body)
+		}
+	}
+}
+
+func TestInfoHiddenCounts(t *testing.T) {
+	useTestStores(t)
+
+	link, token := testUpload(t, "a.txt", "hello", map[string]string{"X-Hide-Downloads": "true"})
+	tests := []struct {
+		headers map[string]string
+		counts  bool
+	}{
+		{nil, false},
+		{map[string]string{"X-Delete-Token": "wrong"}, false},
+		{map[string]string{"X-Delete-Token": token}, true},
+	}
+	for _, tt := range tests {
+		resp := serve(t, testRequest("GET", "/info"+link, tt.headers, ""))
+		if got := strings.Contains(resp.Body, `"downloads"`); got != tt.counts {
+			t.Errorf("%v: counts shown %v: %s", tt.headers, got, resp.Body)
+		}
+	}
+}
+
+func TestInfoNotFoundIsDelayed(t *testing.T) {
+	useTestStores(t)
+	setDuration(t, &notFoundDelayMin, 30*time.Millisecond)
+	setDuration(t, &notFoundDelayMax, 40*time.Millisecond)
+
+	for _, target := range []string{"/info/nosuchkey/a.txt", "/info/nosuchkey"} {
+		start := time.Now()
+		resp := serve(t, testRequest("GET", target, nil, ""))
+		if resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("%s: %d", target, resp.StatusCode)
+		}
+		if d := time.Since(start); d < 30*time.Millisecond {
+			t.Errorf("%s answered after %v, want at least 30ms", target, d)
+		}
+	}
+}
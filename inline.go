@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// defaultPreviewTypes are the content types a browser may render in place
+// when a download asks for ?inline=1, unless INLINE_TYPES says otherwise.
+// Anything able to run script stays out.
+var defaultPreviewTypes = []string{
+	"image/png",
+	"image/jpeg",
+	"image/gif",
+	"image/webp",
+	"application/pdf",
+}
+
+// wantsInline reports whether the download asked to be shown in the
+// browser rather than saved.
+func wantsInline(req events.APIGatewayProxyRequest) bool {
+	v := req.QueryStringParameters["inline"]
+	return v == "1" || v == "true"
+}
+
+// sanitizeFilename makes name safe to quote in a Content-Disposition: no
+// directories, quotes, backslashes or control characters.
+func sanitizeFilename(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "" {
+		return "download"
+	}
+	return name
+}
+
+// inline is the Content-Disposition of a preview named filename.
+func inline(filename string) string {
+	return fmt.Sprintf(`inline; filename="%s"`, sanitizeFilename(filename))
+}
+
+// previewable reports whether item may be rendered in place. The type it
+// is served with, the type its content sniffed as and the type its
+// extension implies must all be the same safe type; a png named .html or
+// labelled text/html is downloaded instead. Records from before sniffing
+// was recorded are previewed only once SNIFF_LEGACY has typed them.
+func previewable(item *transferItem) bool {
+	sniffed := item.SniffedType
+	if !previewTypes[sniffed] {
+		return false
+	}
+	return mediaType(item.ContentType) == sniffed &&
+		mediaType(mime.TypeByExtension(path.Ext(downloadName(item)))) == sniffed
+}
+
+// disposition picks how item is served: in place when a preview was asked
+// for and the item is previewable, as a download otherwise.
+func disposition(req events.APIGatewayProxyRequest, item *transferItem) string {
+	name := downloadName(item)
+	if wantsInline(req) && previewable(item) {
+		return inline(name)
+	}
+	return attachment(name)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const pngHead = "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"photo.png", "photo.png"},
+		{"../../etc/passwd", "passwd"},
+		{`dir\file.pdf`, "file.pdf"},
+		{`say "cheese".png`, "say cheese.png"},
+		{"line\r\nbreak.png", "linebreak.png"},
+		{"", "download"},
+		{"dir/", "download"},
+	}
+	for _, tt := range tests {
+		if got := sanitizeFilename(tt.name); got != tt.want {
+			t.Errorf("sanitizeFilename(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPreviewable(t *testing.T) {
+	tests := []struct {
+		item transferItem
+		want bool
+	}{
+		{transferItem{Filename: "a.png", ContentType: "image/png", SniffedType: "image/png"}, true},
+		{transferItem{Filename: "a.pdf", ContentType: "application/pdf", SniffedType: "application/pdf"}, true},
+		{transferItem{Filename: "a.html", ContentType: "image/png", SniffedType: "image/png"}, false},
+		{transferItem{Filename: "a.png", ContentType: "text/html", SniffedType: "image/png"}, false},
+		{transferItem{Filename: "a.png", ContentType: "image/png", SniffedType: "text/html; charset=utf-8"}, false},
+		{transferItem{Filename: "a.svg", ContentType: "image/svg+xml", SniffedType: "image/svg+xml"}, false},
+		{transferItem{Filename: "a.png", ContentType: "image/png"}, false},
+	}
+	for _, tt := range tests {
+		if got := previewable(&tt.item); got != tt.want {
+			t.Errorf("previewable(%+v) = %v", tt.item, got)
+		}
+	}
+}
+
+func TestInlineDisposition(t *testing.T) {
+	useTestStores(t)
+	setInt(t, &maxDownloads, 100)
+	setString(t, &downloadMode, downloadProxy)
+
+	png, _ := testUpload(t, "cat.png", pngHead, nil)
+	fake, _ := testUpload(t, "cat.html", pngHead, nil)
+
+	tests := []struct {
+		link, query string
+		want        string
+	}{
+		{png, "?inline=1", `inline; filename="cat.png"`},
+		{png, "?inline=true", `inline; filename="cat.png"`},
+		{png, "", `attachment; filename="cat.png"`},
+		{png, "?inline=0", `attachment; filename="cat.png"`},
+		{fake, "?inline=1", `attachment; filename="cat.html"`},
+	}
+	for _, tt := range tests {
+		resp := serve(t, testRequest("GET", tt.link+tt.query, nil, ""))
+		if resp.StatusCode != http.StatusOK || resp.Headers["Content-Disposition"] != tt.want {
+			t.Errorf("GET %s%s: %d, %q, want %q", tt.link, tt.query, resp.StatusCode, resp.Headers["Content-Disposition"], tt.want)
+		}
+	}
+}
+
+func TestInlinePresign(t *testing.T) {
+	useTestStores(t)
+	st, _ := useTestS3(t, ok)
+	objects = st
+	setString(t, &downloadMode, downloadRedirect)
+
+	link, _ := testUpload(t, "cat.png", pngHead, nil)
+	resp := serve(t, testRequest("GET", link+"?inline=1", nil, ""))
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("download: %d", resp.StatusCode)
+	}
+	u, err := url.Parse(resp.Headers["Location"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.Query().Get("response-content-disposition"); got != `inline; filename="cat.png"` {
+		t.Errorf("presigned disposition %q", got)
+	}
+}
+
+// usePreviewTypes sets INLINE_TYPES for the length of the test.
+func usePreviewTypes(t *testing.T, types ...string) {
+	old := previewTypes
+	previewTypes = map[string]bool{}
+	for _, ct := range types {
+		previewTypes[ct] = true
+	}
+	t.Cleanup(func() { previewTypes = old })
+}
+
+func TestInlineTypePairs(t *testing.T) {
+	useTestStores(t)
+	setInt(t, &maxDownloads, 100)
+	setString(t, &downloadMode, downloadProxy)
+	usePreviewTypes(t, "image/png", "text/plain")
+
+	gif := "GIF89a\x01\x00\x01\x00"
+	tests := []struct {
+		name, contentType, body string
+		inline                  bool
+	}{
+		{"a.png", "", pngHead, true},
+		{"a.PNG", "", pngHead, true},
+		{"a.txt", "", "plain words", true},
+		{"a.gif", "", gif, false},
+		{"a.png", "", gif, false},
+		{"a.txt", "", pngHead, false},
+		{"a.png", "text/plain", pngHead, false},
+		{"a.html", "", "plain words", false},
+		{"a.js", "text/plain", "plain words", false},
+	}
+	for _, tt := range tests {
+		var headers map[string]string
+		if tt.contentType != "" {
+			headers = map[string]string{"Content-Type": tt.contentType}
+		}
+		link, _ := testUpload(t, tt.name, tt.body, headers)
+
+		resp := serve(t, testRequest("GET", link+"?inline=1", nil, ""))
+		want := attachment(tt.name)
+		if tt.inline {
+			want = inline(tt.name)
+		}
+		if resp.Headers["Content-Disposition"] != want {
+			t.Errorf("%s as %q holding %q: %q, want %q", tt.name, tt.contentType, tt.body, resp.Headers["Content-Disposition"], want)
+		}
+	}
+}
+
+func TestUploadLinksJSON(t *testing.T) {
+	useTestStores(t)
+	setInt(t, &maxDownloads, 10)
+	setString(t, &downloadMode, downloadProxy)
+
+	tests := []struct {
+		name, body string
+		preview    bool
+	}{
+		{"cat.png", pngHead, true},
+		{"doc.pdf", "%PDF-1.4 doc", true},
+		{"data.zip", "PK\x03\x04rest", false},
+		{"cat.html", pngHead, false},
+		{"page.html", "<html><body>hi</body></html>", false},
+	}
+	for _, tt := range tests {
+		resp := serve(t, testRequest("PUT", "/"+tt.name, map[string]string{"Accept": "application/json"}, tt.body))
+		if resp.StatusCode != http.StatusOK || resp.Headers["Content-Type"] != "application/json" {
+			t.Fatalf("%s: %d as %q", tt.name, resp.StatusCode, resp.Headers["Content-Type"])
+		}
+		var links uploadLinks
+		if err := json.Unmarshal([]byte(resp.Body), &links); err != nil {
+			t.Fatalf("%s: %q: %v", tt.name, resp.Body, err)
+		}
+		if !strings.HasPrefix(links.URL, testDomain+"/") || !strings.HasSuffix(links.URL, "/"+tt.name) {
+			t.Errorf("%s: url %q", tt.name, links.URL)
+		}
+		if (links.PreviewURL != "") != tt.preview {
+			t.Errorf("%s: preview url %q", tt.name, links.PreviewURL)
+		}
+		if !tt.preview {
+			continue
+		}
+		if links.PreviewURL != links.URL+"?inline=1" {
+			t.Errorf("%s: preview url %q for %q", tt.name, links.PreviewURL, links.URL)
+		}
+
+		// the two links differ only in how the file is served
+		for _, link := range []string{links.URL, links.PreviewURL} {
+			resp := serve(t, testRequest("GET", strings.TrimPrefix(link, testDomain), nil, ""))
+			inline := strings.HasPrefix(resp.Headers["Content-Disposition"], "inline")
+			if resp.StatusCode != http.StatusOK || inline != (link == links.PreviewURL) {
+				t.Errorf("%s: GET %s: %d, %q", tt.name, link, resp.StatusCode, resp.Headers["Content-Disposition"])
+			}
+		}
+	}
+}
+
+func TestUploadLinksPlain(t *testing.T) {
+	useTestStores(t)
+
+	for _, accept := range []string{"", "text/plain", "*/*"} {
+		resp := serve(t, testRequest("PUT", "/cat.png", map[string]string{"Accept": accept}, pngHead))
+		if resp.StatusCode != http.StatusOK || strings.Contains(resp.Body, "{") || strings.Contains(resp.Body, "inline") {
+			t.Errorf("Accept %q: %d %q", accept, resp.StatusCode, resp.Body)
+		}
+		if !strings.HasPrefix(resp.Body, testDomain+"/") || strings.Count(resp.Body, "\n") > 1 {
+			t.Errorf("Accept %q: body %q is not the bare url", accept, resp.Body)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestContentLength(t *testing.T) {
+	tests := []struct {
+		name, method, mode, prefix string
+		status                     int
+		header                     string
+	}{
+		{"head", "HEAD", downloadRedirect, "", http.StatusOK, "Content-Length"},
+		{"proxy", "GET", downloadProxy, "", http.StatusOK, "Content-Length"},
+		{"redirect", "GET", downloadRedirect, "", http.StatusFound, "X-Content-Length"},
+		{"raw url", "GET", downloadRedirect, "/url", http.StatusOK, "X-Content-Length"},
+	}
+	for _, tt := range tests {
+		useTestStores(t)
+		setString(t, &downloadMode, tt.mode)
+
+		for _, body := range []string{"hello world", ""} {
+			link, _ := testUpload(t, "a.txt", body, nil)
+			resp := serve(t, testRequest(tt.method, tt.prefix+link, nil, ""))
+			if resp.StatusCode != tt.status {
+				t.Fatalf("%s of %d bytes: %d, want %d", tt.name, len(body), resp.StatusCode, tt.status)
+			}
+			if got, want := resp.Headers[tt.header], strconv.Itoa(len(body)); got != want {
+				t.Errorf("%s of %d bytes: %s %q, want %q", tt.name, len(body), tt.header, got, want)
+			}
+		}
+	}
+}
+
+func TestContentLengthGzip(t *testing.T) {
+	useTestStores(t)
+	setString(t, &downloadMode, downloadProxy)
+	setInt(t, &compressMinSize, 0)
+
+	link, _ := testUpload(t, "a.txt", strings.Repeat("hello ", 100), nil)
+	resp := serve(t, testRequest("GET", link, map[string]string{"Accept-Encoding": "gzip"}, ""))
+	if resp.Headers["Content-Encoding"] != "gzip" {
+		t.Fatalf("not compressed: %v", resp.Headers)
+	}
+	body, err := base64.StdEncoding.DecodeString(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Headers["Content-Length"] != strconv.Itoa(len(body)) {
+		t.Errorf("Content-Length %q, want the %d compressed bytes", resp.Headers["Content-Length"], len(body))
+	}
+}
+
+func TestContentLengthUnknown(t *testing.T) {
+	tests := []struct {
+		method, mode, header string
+	}{
+		{"HEAD", downloadRedirect, "Content-Length"},
+		{"GET", downloadRedirect, "X-Content-Length"},
+	}
+	for _, tt := range tests {
+		st, _ := useTestStores(t)
+		setString(t, &downloadMode, tt.mode)
+
+		// records from before sizes were kept hold neither size nor hash
+		link, _ := testUpload(t, "a.txt", "hello", nil)
+		delete(st.items[keyOf(link)], "size")
+		delete(st.items[keyOf(link)], "sha256")
+
+		resp := serve(t, testRequest(tt.method, link, nil, ""))
+		if resp.StatusCode >= 400 {
+			t.Fatalf("%s: %d", tt.method, resp.StatusCode)
+		}
+		if v, ok := resp.Headers[tt.header]; ok {
+			t.Errorf("%s of an unknown size: %s %q", tt.method, tt.header, v)
+		}
+	}
+}
@@ -3,10 +3,16 @@ package main
 import (
 	"context"
 	"crypto/rand"
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"net/mail"
 	"os"
+	"path"
 	"strconv"
 	"strings"
 	"time"
@@ -14,30 +20,167 @@ import (
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/dynamodb"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
-	"github.com/aws/aws-sdk-go/service/s3"
 )
 
 var (
-	sess *session.Session
+	sess    *session.Session
+	objects storage
+	meta    metaStore
 
 	region     string
 	domain     string
 	s3Bucket   string
 	dynmoTable string
 	keyLen     int
+
+	domainFromHost     bool
+	allowedDomains     []string
+	rejectUnknownHosts bool
+
+	maxDownloads int
+
+	objectKeyLayout string
+
+	receiptSecret  []byte
+	expiryPolicy   []expiryRule
+	maxExpireHours int
+	maxExpireMode  string
+	allowPermanent bool
+
+	notFoundDelayMin time.Duration
+	notFoundDelayMax time.Duration
+
+	scanMode  string
+	scanRules []scanRule
+
+	missingFilename   string
+	defaultFilename   string
+	filenameOptional  bool
+	allowedExtensions map[string]bool
+	previewTypes      map[string]bool
+
+	downloadPage bool
+	previewKinds map[string]string
+
+	apiKeys          map[string]bool
+	uploadWindow     time.Duration
+	uploadLimit      int
+	uploadBytesLimit int64
+	uploaderIndex    string
+	uniqueFilenames  string
+	dynamoShards     int
+
+	publicRead    string
+	publicBaseURL string
+
+	presignTTL time.Duration
+
+	compressMinSize int
+
+	captchaVerifyURL string
+	captchaSecret    string
+
+	bulkDeleteConcurrency int
+
+	vanitySlugs       bool
+	slugCooldown      time.Duration
+	slugAttemptLimit  int
+	slugAttemptWindow time.Duration
+
+	slugCaseInsensitive bool
+
+	auditTable   string
+	auditPresign bool
+
+	metricsNamespace string
+	accessLogFormat  string
+
+	termsURL string
+
+	rotateOnAccess bool
+	verifyObject   bool
+	sniffLegacy    bool
+
+	verboseResponse bool
+
+	errorPages       bool
+	errorPageMessage string
+	errorPageLink    string
+
+	policyURL      string
+	policyTimeout  time.Duration
+	policyFailOpen bool
+
+	putRetries   int
+	putRetryBase time.Duration
+
+	retryBudgetLimit time.Duration
+
+	webhookSecret       []byte
+	expiryWebhookURL    string
+	expiryWarningWindow time.Duration
+
+	maxConcurrentDownloads int
+	dailyDownloadLimit     int
+	downloadSlotTTL        time.Duration
+
+	downloadMode     string
+	proxyReadTimeout time.Duration
+
+	sesSender      string
+	notifyInterval time.Duration
 )
 
 var (
 	defaultKeyLen = 5
+
+	defaultMaxDownloads = 3
+	defaultExpire       = 3 * 24 * time.Hour
+
+	// no upload outlives this many hours, unless ALLOW_PERMANENT lets it
+	// never expire
+	defaultMaxExpireHours = 30 * 24
+
+	defaultUploadWindow = time.Hour
+	defaultPresignTTL   = 15 * time.Minute
+	defaultCompressMin  = 1024
+	defaultNotifyPeriod = time.Hour
+
+	defaultBulkDeleteConc = 8
+
+	defaultSlugAttemptWindow = time.Hour
+
+	defaultPutRetries   = 3
+	defaultPutRetryBase = 100 * time.Millisecond
+
+	defaultDownloadSlotTTL  = time.Minute
+	defaultProxyReadTimeout = 10 * time.Second
+
+	defaultPolicyTimeout = 2 * time.Second
 )
 
 func init() {
+	if id := os.Getenv("SECRETS_ID"); id != "" {
+		cfg := &aws.Config{
+			Region: aws.String(os.Getenv("REGION")),
+		}
+		if err := loadSecrets(cfg, id); err != nil {
+			log.Fatalf("loading SECRETS_ID: %v", err)
+		}
+	}
+
 	region = os.Getenv("REGION")
 	domain = os.Getenv("DOMAIN")
+	domainFromHost = os.Getenv("DOMAIN_FROM_HOST") == "true"
+	for _, d := range envList("ALLOWED_DOMAINS") {
+		allowedDomains = append(allowedDomains, strings.ToLower(d))
+	}
+	rejectUnknownHosts = os.Getenv("REJECT_UNKNOWN_HOSTS") == "true"
+	if rejectUnknownHosts && len(allowedDomains) == 0 {
+		log.Fatalf("REJECT_UNKNOWN_HOSTS needs ALLOWED_DOMAINS")
+	}
 	s3Bucket = os.Getenv("S3_BUCKET")
 	dynmoTable = os.Getenv("DYNMO_TABLE")
 
@@ -48,18 +191,344 @@ func init() {
 		keyLen = l
 	}
 
-	sess = session.Must(session.NewSession(&aws.Config{
+	maxDownloads = envInt("MAX_DOWNLOADS", defaultMaxDownloads)
+
+	objectKeyLayout = strings.Trim(os.Getenv("OBJECT_KEY_LAYOUT"), "/")
+
+	receiptSecret = []byte(os.Getenv("RECEIPT_SECRET"))
+
+	expiryPolicy, err = parseExpiryPolicy(os.Getenv("EXPIRY_POLICY"))
+	if err != nil {
+		log.Fatalf("invalid EXPIRY_POLICY: %v", err)
+	}
+
+	maxExpireHours = envInt("MAX_EXPIRE_HOURS", defaultMaxExpireHours)
+	if maxExpireHours <= 0 || maxExpireHours > maxDurationHours {
+		log.Fatalf("invalid MAX_EXPIRE_HOURS: %d", maxExpireHours)
+	}
+	maxExpireMode = os.Getenv("MAX_EXPIRE_MODE")
+	switch maxExpireMode {
+	case "":
+		maxExpireMode = expireClamp
+	case expireClamp, expireReject:
+	default:
+		log.Fatalf("invalid MAX_EXPIRE_MODE: %q", maxExpireMode)
+	}
+	allowPermanent = os.Getenv("ALLOW_PERMANENT") == "true"
+
+	notFoundDelayMin = envDuration("NOT_FOUND_DELAY_MIN", 0)
+	notFoundDelayMax = envDuration("NOT_FOUND_DELAY_MAX", 0)
+
+	scanMode = os.Getenv("SCAN_MODE")
+	if scanMode != "" {
+		if scanMode != scanReject && scanMode != scanFlag {
+			log.Fatalf("invalid SCAN_MODE: %q", scanMode)
+		}
+		scanRules, err = parseScanRules(os.Getenv("SCAN_PATTERNS"))
+		if err != nil {
+			log.Fatalf("invalid SCAN_PATTERNS: %v", err)
+		}
+	}
+
+	missingFilename = os.Getenv("MISSING_FILENAME")
+	switch missingFilename {
+	case "":
+		missingFilename = missingFilenameKey
+	case missingFilenameKey, missingFilenameReject:
+	default:
+		log.Fatalf("invalid MISSING_FILENAME: %q", missingFilename)
+	}
+	defaultFilename = os.Getenv("DEFAULT_FILENAME")
+	filenameOptional = os.Getenv("FILENAME_OPTIONAL") == "true"
+
+	allowedExtensions = map[string]bool{}
+	for _, ext := range envList("ALLOWED_EXTENSIONS") {
+		allowedExtensions["."+strings.TrimPrefix(strings.ToLower(ext), ".")] = true
+	}
+
+	previewTypes = map[string]bool{}
+	inlineTypes := envList("INLINE_TYPES")
+	if len(inlineTypes) == 0 {
+		inlineTypes = defaultPreviewTypes
+	}
+	for _, t := range inlineTypes {
+		previewTypes[strings.ToLower(t)] = true
+	}
+
+	downloadPage = os.Getenv("DOWNLOAD_PAGE") == "true"
+	previewKinds = defaultPreviewKinds
+	if entries := envList("PREVIEW_KINDS"); len(entries) > 0 {
+		if previewKinds, err = parsePreviewKinds(entries); err != nil {
+			log.Fatalf("invalid PREVIEW_KINDS: %v", err)
+		}
+	}
+
+	apiKeys = map[string]bool{}
+	for _, k := range envList("API_KEYS") {
+		apiKeys[k] = true
+	}
+
+	uploadWindow = envDuration("UPLOAD_WINDOW", defaultUploadWindow)
+	uploadLimit = envInt("UPLOAD_LIMIT", 0)
+	uploadBytesLimit = int64(envInt("UPLOAD_BYTES_LIMIT", 0))
+	uploaderIndex = os.Getenv("UPLOADER_INDEX")
+
+	uniqueFilenames = os.Getenv("UNIQUE_FILENAMES")
+	switch uniqueFilenames {
+	case "", "allow":
+		uniqueFilenames = ""
+	case uniqueReject, uniqueVersion:
+	default:
+		log.Fatalf("invalid UNIQUE_FILENAMES: %q", uniqueFilenames)
+	}
+	// DynamoDB finds an uploader's links only through the index
+	if backend := os.Getenv("META_BACKEND"); uniqueFilenames != "" && uploaderIndex == "" && (backend == "" || backend == "dynamodb") {
+		log.Fatalf("UNIQUE_FILENAMES needs UPLOADER_INDEX")
+	}
+	dynamoShards = envInt("DYNAMO_SHARDS", 0)
+
+	publicRead = os.Getenv("PUBLIC_READ")
+	switch publicRead {
+	case "":
+		publicRead = publicNever
+	case publicNever, publicOptional, publicAlways:
+	default:
+		log.Fatalf("invalid PUBLIC_READ: %q", publicRead)
+	}
+	publicBaseURL = os.Getenv("PUBLIC_BASE_URL")
+	if publicBaseURL == "" {
+		publicBaseURL = fmt.Sprintf("https://%s.s3.%s.amazonaws.com", s3Bucket, region)
+	}
+
+	presignTTL = clampPresignTTL(envDuration("PRESIGN_TTL", defaultPresignTTL))
+
+	compressMinSize = envInt("COMPRESS_MIN_SIZE", defaultCompressMin)
+
+	captchaVerifyURL = os.Getenv("CAPTCHA_VERIFY_URL")
+	captchaSecret = os.Getenv("CAPTCHA_SECRET")
+
+	bulkDeleteConcurrency = envInt("BULK_DELETE_CONCURRENCY", defaultBulkDeleteConc)
+	if bulkDeleteConcurrency < 1 {
+		bulkDeleteConcurrency = 1
+	}
+
+	vanitySlugs = os.Getenv("VANITY_SLUGS") == "true"
+	slugCooldown = envDuration("SLUG_COOLDOWN", 0)
+	slugAttemptLimit = envInt("SLUG_ATTEMPT_LIMIT", 0)
+	slugAttemptWindow = envDuration("SLUG_ATTEMPT_WINDOW", defaultSlugAttemptWindow)
+	slugCaseInsensitive = os.Getenv("SLUG_CASE_INSENSITIVE") == "true"
+
+	auditTable = os.Getenv("AUDIT_TABLE")
+	auditPresign = os.Getenv("AUDIT_PRESIGN") == "true"
+	metricsNamespace = os.Getenv("METRICS_NAMESPACE")
+	accessLogFormat = os.Getenv("LOG_FORMAT")
+	switch accessLogFormat {
+	case "", logJSON, logCommon, logCombined:
+	default:
+		log.Fatalf("invalid LOG_FORMAT: %q", accessLogFormat)
+	}
+	termsURL = os.Getenv("TERMS_URL")
+	rotateOnAccess = os.Getenv("ROTATE_ON_ACCESS") == "true"
+	verifyObject = os.Getenv("VERIFY_OBJECT") == "true"
+	sniffLegacy = os.Getenv("SNIFF_LEGACY") == "true"
+
+	verboseResponse = os.Getenv("VERBOSE_RESPONSE") == "true"
+
+	errorPages = os.Getenv("ERROR_PAGES") == "true"
+	errorPageMessage = os.Getenv("ERROR_PAGE_MESSAGE")
+	errorPageLink = os.Getenv("ERROR_PAGE_LINK")
+
+	policyURL = os.Getenv("POLICY_URL")
+	policyTimeout = envDuration("POLICY_TIMEOUT", defaultPolicyTimeout)
+	policyFailOpen = os.Getenv("POLICY_FAIL_OPEN") == "true"
+
+	putRetries = envInt("S3_PUT_RETRIES", defaultPutRetries)
+	putRetryBase = envDuration("S3_PUT_RETRY_BASE", defaultPutRetryBase)
+	retryBudgetLimit = envDuration("RETRY_BUDGET", 0)
+
+	webhookSecret = []byte(os.Getenv("WEBHOOK_SECRET"))
+	expiryWebhookURL = os.Getenv("EXPIRY_WEBHOOK_URL")
+	expiryWarningWindow = envDuration("EXPIRY_WARNING_WINDOW", 0)
+
+	maxConcurrentDownloads = envInt("MAX_CONCURRENT_DOWNLOADS", 0)
+	dailyDownloadLimit = envInt("DAILY_DOWNLOAD_LIMIT", 0)
+	downloadSlotTTL = envDuration("DOWNLOAD_SLOT_TTL", defaultDownloadSlotTTL)
+	if downloadSlotTTL < time.Second {
+		downloadSlotTTL = defaultDownloadSlotTTL
+	}
+
+	downloadMode = os.Getenv("DOWNLOAD_MODE")
+	switch downloadMode {
+	case "":
+		downloadMode = downloadRedirect
+	case downloadRedirect, downloadProxy:
+	default:
+		log.Fatalf("invalid DOWNLOAD_MODE: %q", downloadMode)
+	}
+	proxyReadTimeout = envDuration("PROXY_READ_TIMEOUT", defaultProxyReadTimeout)
+
+	sesSender = os.Getenv("SES_SENDER")
+	notifyInterval = envDuration("NOTIFY_INTERVAL", defaultNotifyPeriod)
+
+	cfg := &aws.Config{
 		Region: aws.String(region),
-	}))
+	}
+	if retryBudgetLimit > 0 {
+		cfg = request.WithRetryer(cfg, newBudgetRetryer())
+		cfg.EnforceShouldRetryCheck = aws.Bool(true)
+	}
+	sess = session.Must(session.NewSession(cfg))
+
+	objects, err = newStorage(os.Getenv("STORAGE_BACKEND"))
+	if err != nil {
+		log.Fatalf("invalid storage: %v", err)
+	}
+	meta, err = newMetaStore(os.Getenv("META_BACKEND"))
+	if err != nil {
+		log.Fatalf("invalid metadata store: %v", err)
+	}
+
+	if st, ok := objects.(*fsStorage); ok && st.baseURL == "" {
+		st.baseURL = "http://" + os.Getenv("DEV_ADDR") + strings.TrimSuffix(devFilesPrefix, "/")
+	}
 }
 
+// envDuration parses a duration such as "150ms" from the environment,
+// returning def when it is unset or malformed.
+func envDuration(name string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(name))
+	if err != nil {
+		return def
+	}
+	return d
+}
+
+// envInt parses an integer from the environment, returning def when it is
+// unset or malformed.
+func envInt(name string, def int) int {
+	n, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+// envList splits a comma separated environment variable, dropping blanks.
+func envList(name string) []string {
+	var l []string
+	for _, s := range strings.Split(os.Getenv(name), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			l = append(l, s)
+		}
+	}
+	return l
+}
+
+// transferItem is the record of an upload. Records outlive the code that
+// wrote them, so older ones lack attributes added since, which read as the
+// zero value and mean:
+//
+//   - no times: not downloaded yet
+//   - no max_times: the current MAX_DOWNLOADS applies (DownloadLimit)
+//   - no expire_at: the upload never expires, like a permanent link
+//   - no created_at or size: unknown, left out of info and of the
+//     Content-Length of downloads (knownSize)
+//   - no content_type: served as application/octet-stream, unless
+//     SNIFF_LEGACY sniffs one (sniffLegacyType)
+//   - no object_key: the object is stored under s3key (ObjectPath)
+//   - no version_id: the latest version of the object is served
 type transferItem struct {
 	S3Key string `json:"s3key"`
 
-	Filename string `json:"filename"`
-	IP       string `json:"ip"`
-	ExpireAt int64  `json:"expire_at"`
-	Times    int    `json:"times"`
+	// ObjectKey is where the file lives in storage when that differs from
+	// the public key, e.g. under a date prefix.
+	ObjectKey string `json:"object_key,omitempty"`
+
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type,omitempty"`
+
+	// SniffedType is the media type the content itself looked like on
+	// upload, whatever the client claimed.
+	SniffedType string `json:"sniffed_type,omitempty"`
+
+	IP        string `json:"ip"`
+	Uploader  string `json:"uploader,omitempty"`
+	CreatedAt int64  `json:"created_at,omitempty"`
+	ExpireAt  int64  `json:"expire_at,omitempty"`
+	Times     int    `json:"times"`
+	MaxTimes  int    `json:"max_times,omitempty"`
+
+	// MaxConcurrent caps concurrent downloads below the global cap.
+	MaxConcurrent int `json:"max_concurrent,omitempty"`
+
+	Size   int64  `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+
+	// VersionID is the version of the object this upload stored, in a
+	// versioned bucket. Downloads ask for it, so that a later object under
+	// the same key is never served instead.
+	VersionID string `json:"version_id,omitempty"`
+
+	// DeleteTokenHash is the sha256 of the token that lets the uploader
+	// delete the file.
+	DeleteTokenHash string `json:"delete_token_hash,omitempty"`
+
+	// PasswordHash is the hashed X-Password downloads must present.
+	PasswordHash string `json:"password_hash,omitempty"`
+
+	// ViewPasswordHash is the hashed X-View-Password that looking at the
+	// metadata or preview takes.
+	ViewPasswordHash string `json:"view_password_hash,omitempty"`
+
+	// NotifyEmail is mailed whenever the upload is downloaded.
+	NotifyEmail string `json:"notify_email,omitempty"`
+
+	// HideDownloads keeps the download counts from everyone but the owner.
+	HideDownloads bool `json:"hide_downloads,omitempty"`
+
+	// Slug is set when the key was chosen by the uploader.
+	Slug bool `json:"slug,omitempty"`
+
+	// RotatedTo is the key a ROTATE_ON_ACCESS download moved the upload
+	// to. The record stays, not served, for the owner to follow.
+	RotatedTo string `json:"rotated_to,omitempty"`
+
+	// Disabled uploads are kept but not served. Operators set it by hand.
+	Disabled bool `json:"disabled,omitempty"`
+
+	// Public objects are readable straight from the bucket.
+	Public bool `json:"public,omitempty"`
+
+	// Flagged lists the scan rules an upload matched when SCAN_MODE=flag.
+	Flagged []string `json:"flagged,omitempty"`
+}
+
+var errNotFound = errors.New("not found")
+
+// DownloadLimit is the number of downloads the upload allows.
+func (k *transferItem) DownloadLimit() int {
+	if k.MaxTimes > 0 {
+		return k.MaxTimes
+	}
+	return maxDownloads
+}
+
+// ObjectPath is the storage key of the file.
+func (k *transferItem) ObjectPath() string {
+	if k.ObjectKey != "" {
+		return k.ObjectKey
+	}
+	return k.S3Key
+}
+
+// objectKeyFor returns the storage key of an upload made at t under
+// OBJECT_KEY_LAYOUT, such as "2006/01/02" for "2024/06/01/{key}", or an
+// empty string when objects are stored under their bare key.
+func objectKeyFor(s3key string, t time.Time) string {
+	if objectKeyLayout == "" {
+		return ""
+	}
+	return t.UTC().Format(objectKeyLayout) + "/" + s3key
 }
 
 func (k *transferItem) GenKey() error {
@@ -72,147 +541,739 @@ func (k *transferItem) GenKey() error {
 	return nil
 }
 
+// header looks up a request header case-insensitively, as API Gateway
+// passes them through in whatever case the client sent.
+func header(req events.APIGatewayProxyRequest, name string) string {
+	for k, v := range req.Headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
+
+// requestBody returns the raw upload, decoding it when API Gateway delivered
+// a binary payload as base64.
+func requestBody(req events.APIGatewayProxyRequest) ([]byte, error) {
+	if req.IsBase64Encoded {
+		return base64.StdEncoding.DecodeString(req.Body)
+	}
+	return []byte(req.Body), nil
+}
+
 func handleRequest(ctx context.Context, req events.APIGatewayProxyRequest) (resp events.APIGatewayProxyResponse, err error) {
+	start := time.Now()
+	ctx = withRetryBudget(ctx)
+	resp, err = route(ctx, req)
+
+	// a request that ran out of retries is worth trying again later
+	if resp.StatusCode >= 500 && retryBudgetOf(ctx).exhausted() {
+		if err != nil {
+			log.Printf("retry budget spent: %v", err)
+		}
+		resp = events.APIGatewayProxyResponse{
+			StatusCode: http.StatusServiceUnavailable,
+			Headers: map[string]string{
+				"Retry-After": "1",
+			},
+			Body: "service busy, try again\n",
+		}
+		err = nil
+	}
+	errorPage(req, &resp)
+	compressResponse(req, &resp)
+	logAccess(req, &resp, start)
+	return
+}
+
+// cleanPath normalizes the proxied path before it is parsed: runs of
+// slashes collapse into one and leading or trailing slashes are dropped, so
+// "/key//file/" reads as "key/file" and "key/" as the bare key.
+func cleanPath(p string) string {
+	parts := strings.Split(p, "/")
+	clean := parts[:0]
+	for _, part := range parts {
+		if part != "" {
+			clean = append(clean, part)
+		}
+	}
+	return strings.Join(clean, "/")
+}
+
+func route(ctx context.Context, req events.APIGatewayProxyRequest) (resp events.APIGatewayProxyResponse, err error) {
+	params := map[string]string{}
+	for k, v := range req.PathParameters {
+		params[k] = v
+	}
+	params["proxy"] = cleanPath(params["proxy"])
+	req.PathParameters = params
+
+	if misdirected(req) {
+		resp.StatusCode = http.StatusMisdirectedRequest
+		resp.Body = "unknown host\n"
+		return
+	}
+
 	switch req.RequestContext.HTTPMethod {
 	case http.MethodPut:
 		return put(ctx, req)
+	case http.MethodHead:
+		return head(ctx, req)
+	case http.MethodDelete:
+		return del(ctx, req)
+	case http.MethodPost:
+		if req.PathParameters["proxy"] == "delete" {
+			return bulkDelete(ctx, req)
+		}
+		methodNotAllowed(&resp, req.PathParameters["proxy"])
+		return
 	case http.MethodGet:
+		switch req.PathParameters["proxy"] {
+		case "verify-receipt":
+			return verifyReceipt(ctx, req)
+		case "quota":
+			return quota(ctx, req)
+		}
+		if strings.HasPrefix(req.PathParameters["proxy"], "info/") {
+			return info(ctx, req)
+		}
 		return get(ctx, req)
 
 	default:
-		resp.StatusCode = http.StatusMethodNotAllowed
+		methodNotAllowed(&resp, req.PathParameters["proxy"])
 		return
 	}
 }
 
+// methodNotAllowed answers a method route does not handle for path, listing
+// the ones it does.
+func methodNotAllowed(resp *events.APIGatewayProxyResponse, path string) {
+	allow := "GET, HEAD, PUT, DELETE"
+	if path == "delete" {
+		allow = "GET, HEAD, PUT, POST, DELETE"
+	}
+
+	resp.StatusCode = http.StatusMethodNotAllowed
+	resp.Headers = map[string]string{
+		"Allow": allow,
+	}
+	resp.Body = "method not allowed\n"
+}
+
 func put(ctx context.Context, req events.APIGatewayProxyRequest) (resp events.APIGatewayProxyResponse, err error) {
 	var (
-		av map[string]*dynamodb.AttributeValue
-
-		dynmo = dynamodb.New(sess)
+		now = time.Now()
 
 		r = transferItem{
-			Filename: req.PathParameters["proxy"],
-			IP:       req.RequestContext.Identity.SourceIP,
-			ExpireAt: time.Now().Add(3 * 24 * time.Hour).Unix(),
+			Filename:  req.PathParameters["proxy"],
+			IP:        req.RequestContext.Identity.SourceIP,
+			CreatedAt: now.Unix(),
+			MaxTimes:  maxDownloads,
 		}
 	)
 
-	for {
-		if err = r.GenKey(); err != nil {
+	uploader, authenticated, err := caller(req)
+	if err != nil {
+		resp.StatusCode = http.StatusUnauthorized
+		err = nil
+		return
+	}
+
+	// anonymous uploads must solve a captcha when one is configured
+	if captchaVerifyURL != "" && !authenticated {
+		ok, cerr := verifyCaptcha(ctx, header(req, "X-Captcha-Token"), r.IP)
+		if cerr != nil {
+			resp.StatusCode = http.StatusServiceUnavailable
+			return
+		}
+		if !ok {
+			resp.StatusCode = http.StatusForbidden
+			resp.Body = "captcha verification failed\n"
+			return
+		}
+	}
+	r.Uploader = uploader
+
+	if !acceptedTerms(req) {
+		refuseTerms(&resp)
+		return
+	}
+
+	if !usableFilename(r.Filename) && missingFilename == missingFilenameReject {
+		resp.StatusCode = http.StatusBadRequest
+		resp.Body = "missing filename\n"
+		return
+	}
+
+	body, err := newUploadBody(req)
+	if err != nil {
+		resp.StatusCode = http.StatusBadRequest
+		err = nil
+		return
+	}
+	defer func() {
+		recordUploadSize(body.size, err == nil && resp.StatusCode == http.StatusOK)
+	}()
+	r.ContentType = uploadContentType(req, body.head)
+
+	if len(allowedExtensions) > 0 {
+		name := r.Filename
+		if !usableFilename(name) {
+			name = extensionFor(r.ContentType)
+		}
+		if !allowedExtension(path.Ext(name)) || mislabeled(name, body.head) {
+			resp.StatusCode = http.StatusUnsupportedMediaType
+			resp.Body = "file type not allowed\n"
+			return
+		}
+	}
+
+	// scanning needs the whole body at hand, but only text is scanned
+	if scanMode != "" && strings.HasPrefix(http.DetectContentType(body.head), "text/") {
+		var data []byte
+		if data, err = body.ReadAll(); err != nil {
+			resp.StatusCode = http.StatusBadRequest
+			err = nil
+			return
+		}
+		if hits := scanContent(data); len(hits) > 0 {
+			if scanMode == scanReject {
+				resp.StatusCode = http.StatusUnprocessableEntity
+				resp.Body = "upload rejected: contains " + strings.Join(hits, ", ") + "\n"
+				return
+			}
+			r.Flagged = hits
+		}
+	}
+
+	r.SHA256 = body.sha256
+	r.Size = body.size
+	r.SniffedType = mediaType(http.DetectContentType(body.head))
+
+	if uniqueFilenames != "" && authenticated && usableFilename(r.Filename) {
+		r.Filename, err = uniqueFilename(ctx, r.Uploader, r.Filename)
+		if err == errDuplicateFilename {
+			resp.StatusCode = http.StatusConflict
+			resp.Body = "filename already in use\n"
+			err = nil
+			return
+		}
+		if err != nil {
 			resp.StatusCode = http.StatusInternalServerError
 			return
 		}
+	}
+
+	if d := checkPolicy(ctx, &r); !d.Allow {
+		resp.StatusCode = http.StatusForbidden
+		resp.Body = "upload refused by policy\n"
+		if d.Reason != "" {
+			resp.Body = "upload refused by policy: " + d.Reason + "\n"
+		}
+		return
+	}
+
+	if v := header(req, "X-Notify-On-Download"); v != "" && sesSender != "" {
+		addr, aerr := mail.ParseAddress(v)
+		if aerr != nil {
+			resp.StatusCode = http.StatusBadRequest
+			resp.Body = "invalid notification address\n"
+			return
+		}
+		r.NotifyEmail = addr.Address
+	}
+
+	if v := header(req, "X-Max-Concurrent"); v != "" {
+		if r.MaxConcurrent, err = strconv.Atoi(v); err != nil || r.MaxConcurrent < 0 {
+			resp.StatusCode = http.StatusBadRequest
+			resp.Body = "invalid X-Max-Concurrent\n"
+			err = nil
+			return
+		}
+	}
 
-		av, err = dynamodbattribute.MarshalMap(r)
+	r.Public = publicRead == publicAlways ||
+		publicRead == publicOptional && strings.EqualFold(header(req, "X-Public"), "true")
+	// a public object is readable at its bucket url, which no password guards
+	if r.Public && (header(req, "X-Password") != "" || header(req, "X-View-Password") != "") {
+		resp.StatusCode = http.StatusBadRequest
+		resp.Body = "public uploads cannot be password protected\n"
+		return
+	}
+
+	expire, err := uploadExpiry(req, r.Size)
+	if err == errExpiryTooLong {
+		resp.StatusCode = http.StatusBadRequest
+		resp.Body = fmt.Sprintf("expiry over the maximum of %d hours\n", maxExpireHours)
+		err = nil
+		return
+	}
+	if err != nil {
+		resp.StatusCode = http.StatusBadRequest
+		err = nil
+		return
+	}
+	if expire > 0 {
+		r.ExpireAt = now.Add(expire).Unix()
+	}
+
+	deleteToken, deleteTokenHash, err := genDeleteToken()
+	if err != nil {
+		resp.StatusCode = http.StatusInternalServerError
+		return
+	}
+	r.DeleteTokenHash = deleteTokenHash
+
+	r.HideDownloads = strings.EqualFold(header(req, "X-Hide-Downloads"), "true")
+
+	if password := header(req, "X-Password"); password != "" {
+		if r.PasswordHash, err = hashPassword(password); err != nil {
+			resp.StatusCode = http.StatusInternalServerError
+			return
+		}
+	}
+	if password := header(req, "X-View-Password"); password != "" {
+		if r.ViewPasswordHash, err = hashPassword(password); err != nil {
+			resp.StatusCode = http.StatusInternalServerError
+			return
+		}
+	}
+
+	if slug := normalizeSlug(header(req, "X-Slug")); slug != "" {
+		if !vanitySlugs || !validSlug(slug) {
+			resp.StatusCode = http.StatusBadRequest
+			resp.Body = "invalid slug\n"
+			return
+		}
+
+		err = chargeSlugAttempt(ctx, r.IP)
+		if err == errSlugAttempts {
+			resp.StatusCode = http.StatusTooManyRequests
+			resp.Body = "too many slug attempts\n"
+			err = nil
+			return
+		}
 		if err != nil {
 			resp.StatusCode = http.StatusInternalServerError
 			return
 		}
 
-		_, err = dynmo.PutItem(&dynamodb.PutItemInput{
-			Item:                av,
-			TableName:           aws.String(dynmoTable),
-			ConditionExpression: aws.String("attribute_not_exists(s3key)"),
-		})
+		r.S3Key, r.Slug = slug, true
+	}
+
+	// only uploads that passed every check are charged
+	if err = chargeQuota(ctx, r.Uploader, r.Size, now); err != nil {
+		if err != errQuotaExceeded {
+			resp.StatusCode = http.StatusInternalServerError
+			return
+		}
+		resp.StatusCode = http.StatusTooManyRequests
+		resp.Body = "upload quota exceeded\n"
+		err = nil
+		return
+	}
 
+	for {
+		if !r.Slug {
+			if err = r.GenKey(); err != nil {
+				refundQuota(r.Uploader, r.Size, now)
+				resp.StatusCode = http.StatusInternalServerError
+				return
+			}
+		}
+
+		// give the file a sensible name rather than an empty or generic one
+		if !usableFilename(req.PathParameters["proxy"]) {
+			r.Filename = fallbackFilename(r.S3Key, r.ContentType)
+		}
+		r.ObjectKey = objectKeyFor(r.S3Key, now)
+
+		err = meta.Reserve(ctx, &r)
 		if err == nil {
 			break
 		}
+		if err != errKeyExists {
+			refundQuota(r.Uploader, r.Size, now)
+			resp.StatusCode = http.StatusInternalServerError
+			return
+		}
+		if r.Slug {
+			refundQuota(r.Uploader, r.Size, now)
+			resp.StatusCode = http.StatusConflict
+			resp.Body = "slug is taken\n"
+			err = nil
+			return
+		}
+	}
 
-		aerr, ok := err.(awserr.Error)
-		if !ok || aerr.Code() != dynamodb.ErrCodeConditionalCheckFailedException {
+	// the slug is held only once this upload got it, so that a refused one
+	// never moves the hold of the live upload
+	if r.Slug {
+		if err = holdSlug(ctx, r.S3Key, r.Uploader, time.Unix(r.ExpireAt, 0)); err != nil {
+			if derr := meta.Delete(context.Background(), r.S3Key); derr != nil {
+				log.Printf("release %s: %v", r.S3Key, derr)
+			}
+			refundQuota(r.Uploader, r.Size, now)
+		}
+		if err == errSlugTaken {
+			resp.StatusCode = http.StatusConflict
+			resp.Body = "slug is taken\n"
+			err = nil
+			return
+		}
+		if err != nil {
 			resp.StatusCode = http.StatusInternalServerError
 			return
 		}
 	}
 
-	// upload to s3
-	_, err = s3.New(sess).PutObject(&s3.PutObjectInput{
-		Bucket: aws.String(s3Bucket),
-		Key:    aws.String(r.S3Key),
-		Body:   strings.NewReader(req.Body),
+	// upload to storage
+	r.VersionID, err = objects.Put(ctx, &object{
+		Key:    r.ObjectPath(),
+		Body:   body.Open(),
+		Public: r.Public,
 
-		ContentDisposition: aws.String(fmt.Sprintf(`attachment; filename="%s"`, r.Filename)),
+		ContentType:        r.ContentType,
+		ContentDisposition: attachment(r.Filename),
 	})
 	if err != nil {
 		resp.StatusCode = http.StatusInternalServerError
 
-		dynmo.DeleteItem(&dynamodb.DeleteItemInput{
-			Key: map[string]*dynamodb.AttributeValue{
-				"key": {
-					S: aws.String(r.S3Key),
-				},
-			},
-			TableName: aws.String(dynmoTable),
-		})
+		// release the reserved key, even if the request itself was cancelled
+		if derr := meta.Delete(context.Background(), r.S3Key); derr != nil {
+			log.Printf("release %s: %v", r.S3Key, derr)
+		}
+		refundQuota(r.Uploader, r.Size, now)
 		return
 	}
 
+	// the version is only known now that the record is reserved
+	if r.VersionID != "" {
+		if err = meta.SetString(ctx, r.S3Key, "version_id", r.VersionID); err != nil {
+			log.Printf("record version of %s: %v", r.S3Key, err)
+			err = nil
+		}
+	}
+
+	auditTerms(ctx, r.S3Key, r.IP)
+
+	resp.Headers = map[string]string{
+		"X-Delete-Token": deleteToken,
+	}
+
+	if len(receiptSecret) > 0 {
+		var token string
+		if token, err = issueReceipt(r); err != nil {
+			resp.StatusCode = http.StatusInternalServerError
+			return
+		}
+		resp.Headers["X-Receipt"] = token
+	}
+
 	resp.StatusCode = 200
-	resp.Body = domain + "/" + r.S3Key + "/" + r.Filename
+	base := requestDomain(req)
+	link := base + "/" + r.S3Key + "/" + r.Filename
+	if wantsJSON(req) {
+		var b []byte
+		if b, err = json.Marshal(newUploadLinks(&r, link)); err != nil {
+			resp.StatusCode = http.StatusInternalServerError
+			return
+		}
+		resp.Headers["Content-Type"] = "application/json"
+		resp.Body = string(b)
+		return
+	}
+
+	resp.Body = link
+	if verboseResponse {
+		resp.Body += "\n" + uploadDetails(&r, base, deleteToken)
+	}
 
 	return
 }
 
+// wantsJSON reports whether the uploader asked for the links as JSON
+// rather than the bare url.
+func wantsJSON(req events.APIGatewayProxyRequest) bool {
+	return strings.Contains(header(req, "Accept"), "application/json")
+}
+
+// uploadLinks is the JSON response of an upload. PreviewURL is only given
+// for uploads a browser may render in place (see previewable).
+type uploadLinks struct {
+	URL        string `json:"url"`
+	PreviewURL string `json:"preview_url,omitempty"`
+}
+
+func newUploadLinks(r *transferItem, link string) uploadLinks {
+	l := uploadLinks{URL: link}
+	if previewable(r) {
+		l.PreviewURL = link + "?inline=1"
+	}
+	return l
+}
+
+// uploadDetails are the lines VERBOSE_RESPONSE adds below the url of an
+// upload, for people reading the response of curl.
+func uploadDetails(r *transferItem, base, deleteToken string) string {
+	expires := "never"
+	if r.ExpireAt > 0 {
+		expires = time.Unix(r.ExpireAt, 0).UTC().Format(http.TimeFormat)
+	}
+	return fmt.Sprintf("Expires: %s\nDelete: curl -X DELETE '%s/%s?token=%s'\n",
+		expires, base, r.S3Key, deleteToken)
+}
+
+// splitPath splits a download path into its key and filename. The filename
+// may only be left out when FILENAME_OPTIONAL is set.
+func splitPath(path string) (s3key, filename string, ok bool) {
+	parts := strings.SplitN(path, "/", 2)
+
+	s3key = parts[0]
+	if len(parts) == 2 {
+		filename = parts[1]
+	}
+
+	if s3key == "" || filename == "" && !filenameOptional {
+		return "", "", false
+	}
+	return s3key, filename, true
+}
+
+// get redirects to a presigned download url. Requests under /url/ receive
+// the presigned url in the body instead, for clients that would rather not
+// follow the redirect. Both count as a download.
 func get(ctx context.Context, req events.APIGatewayProxyRequest) (resp events.APIGatewayProxyResponse, err error) {
-	parts := strings.SplitN(req.PathParameters["proxy"], "/", 2)
+	defer func() {
+		if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+			time.Sleep(notFoundDelay())
+		}
+	}()
+
+	path := req.PathParameters["proxy"]
+	raw := strings.HasPrefix(path, "url/")
+	if raw {
+		path = strings.TrimPrefix(path, "url/")
+	}
 
-	if len(parts) != 2 {
+	s3key, _, ok := splitPath(path)
+	if !ok {
 		resp.StatusCode = http.StatusNotFound
 		return
 	}
 
-	s3key, filename := parts[0], parts[1]
-
-	if s3key == "" || filename == "" {
+	item, err := lookup(ctx, s3key)
+	if err == errNotFound {
 		resp.StatusCode = http.StatusNotFound
+		err = nil
+		return
+	}
+	if err != nil {
+		resp.StatusCode = http.StatusInternalServerError
 		return
 	}
+	s3key = item.S3Key
 
-	// sign download url
-	objReq, _ := s3.New(sess).GetObjectRequest(&s3.GetObjectInput{
-		Bucket: aws.String(s3Bucket),
-		Key:    aws.String(s3key),
-	})
+	// the links of a download page were counted with the page
+	fromPage := false
+	if token := req.QueryStringParameters["page"]; token != "" {
+		if fromPage, err = usePageToken(ctx, item, token); err != nil {
+			resp.StatusCode = http.StatusInternalServerError
+			return
+		}
+		if !fromPage {
+			resp.StatusCode = http.StatusForbidden
+			resp.Body = "page expired, reload it\n"
+			return
+		}
+	}
+
+	if fromPage {
+		resp.StatusCode = liveStatus(item, time.Now())
+	} else {
+		resp.StatusCode = itemStatus(item, time.Now())
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Headers = downloadHeaders(item, nil, showsCount(req, item))
+		return
+	}
+
+	if verifyObject {
+		var exists bool
+		if exists, err = objects.Exists(ctx, item.ObjectPath(), item.VersionID); err != nil {
+			resp.StatusCode = http.StatusInternalServerError
+			return
+		}
+		if !exists {
+			dropOrphan(ctx, item)
+			resp.StatusCode = http.StatusGone
+			return
+		}
+	}
+	sniffLegacyType(ctx, item)
 
-	url, err := objReq.Presign(15 * time.Minute)
+	if wantsPage(req) && !raw {
+		if resp.StatusCode = viewPasswordStatus(req, item); resp.StatusCode != http.StatusOK {
+			resp.Body = "password required\n"
+			return
+		}
+		var (
+			token  string
+			charge *dailyCharge
+		)
+		if countsPage(item) {
+			if charge, ok, err = chargeDaily(ctx, &resp, req); !ok {
+				return
+			}
+			token, err = countPageView(ctx, req, item)
+			if err == errLimitReached {
+				charge.refund()
+				resp.StatusCode = http.StatusGone
+				err = nil
+				return
+			}
+			if err != nil {
+				charge.refund()
+				resp.StatusCode = http.StatusInternalServerError
+				return
+			}
+		}
+		if err = sendPage(ctx, &resp, req, item, token); err != nil {
+			charge.refund()
+			resp.StatusCode = http.StatusInternalServerError
+		}
+		return
+	}
+
+	if resp.StatusCode = downloadPasswordStatus(req, item); resp.StatusCode != http.StatusOK {
+		resp.Body = "password required\n"
+		return
+	}
+
+	// public objects are served straight from the bucket and not counted
+	if item.Public {
+		sendURL(&resp, objects.PublicURL(item.ObjectPath()), raw)
+		return
+	}
+
+	slot, err := acquireDownloadSlot(ctx, item)
+	if err == errLimitReached {
+		resp.StatusCode = http.StatusTooManyRequests
+		resp.Headers = map[string]string{
+			"Retry-After": strconv.FormatInt(retryAfter(time.Now()), 10),
+		}
+		err = nil
+		return
+	}
 	if err != nil {
 		resp.StatusCode = http.StatusInternalServerError
 		return
 	}
 
-	// update dynamodb
-	_, err = dynamodb.New(sess).UpdateItem(&dynamodb.UpdateItemInput{
-		Key: map[string]*dynamodb.AttributeValue{
-			"s3key": {
-				S: aws.String(s3key),
-			},
-		},
-		TableName:           aws.String(dynmoTable),
-		ReturnValues:        aws.String("NONE"),
-		UpdateExpression:    aws.String("ADD times :one"),
-		ConditionExpression: aws.String("attribute_exists(s3key) and times < :three"),
-		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":one": {
-				N: aws.String("1"),
-			},
-			":three": {
-				N: aws.String("3"),
-			},
-		},
-	})
+	// the day is only charged for downloads that got a slot, and given back
+	// when they fail below
+	var charge *dailyCharge
+	if !fromPage {
+		if charge, ok, err = chargeDaily(ctx, &resp, req); !ok {
+			slot.release(ctx)
+			return
+		}
+	}
+
+	// in proxy mode small objects are returned in the response itself
+	var (
+		data    []byte
+		proxied bool
+	)
+	if downloadMode == downloadProxy && !raw {
+		data, proxied, err = readObject(ctx, item)
+		if err == errStalled {
+			slot.release(ctx)
+			charge.refund()
+			resp.StatusCode = http.StatusGatewayTimeout
+			resp.Body = "download stalled\n"
+			err = nil
+			return
+		}
+		if err != nil {
+			slot.release(ctx)
+			charge.refund()
+			resp.StatusCode = http.StatusInternalServerError
+			return
+		}
+	}
+
+	// sign download url
+	var (
+		url string
+		ttl = presignTTLFor(item)
+	)
+	if !proxied {
+		url, err = objects.URL(ctx, item.ObjectPath(), ttl, urlOptions{
+			ContentDisposition: disposition(req, item),
+			VersionID:          item.VersionID,
+		})
+		if err != nil {
+			slot.release(ctx)
+			charge.refund()
+			resp.StatusCode = http.StatusInternalServerError
+			return
+		}
+	}
+
+	// count the download
+	if !fromPage {
+		err = meta.CountDownload(ctx, s3key, item.DownloadLimit())
+	}
+	if err != nil || proxied {
+		slot.release(ctx)
+	}
+	if err != nil {
+		charge.refund()
+	}
 
 	if err == nil {
-		resp.StatusCode = http.StatusFound
-		resp.Headers = map[string]string{
-			"Location": url,
+		if auditPresign && !proxied {
+			audit(ctx, auditEvent{
+				Event: "presign",
+				Key:   s3key,
+				IP:    req.RequestContext.Identity.SourceIP,
+				Details: map[string]string{
+					"ttl": ttl.String(),
+				},
+			})
+		}
+		if item.NotifyEmail != "" && !fromPage {
+			notifyDownload(ctx, item, req.RequestContext.Identity.SourceIP)
+		}
+		if proxied {
+			sendObject(&resp, item, data, disposition(req, item))
+		} else {
+			sendURL(&resp, url, raw)
+			// the body is the file's only over there, so tell its size aside
+			if n, ok := knownSize(item); ok {
+				resp.Headers["X-Content-Length"] = strconv.FormatInt(n, 10)
+			}
+		}
+		if !fromPage {
+			item.Times++
+		}
+		downloadHeaders(item, resp.Headers, showsCount(req, item))
+
+		if rotates(item) && !fromPage {
+			next, rerr := rotateKey(ctx, item, requestDomain(req))
+			if rerr != nil {
+				log.Printf("rotate %s: %v", s3key, rerr)
+				return
+			}
+			resp.Headers["Link"] = nextLink(next)
 		}
 		return
 	}
 
-	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
-		resp.StatusCode = http.StatusNotFound
+	// the last download was taken since the record was read
+	if err == errLimitReached {
+		resp.StatusCode = http.StatusGone
 		err = nil
 		return
 	}
@@ -221,6 +1282,68 @@ func get(ctx context.Context, req events.APIGatewayProxyRequest) (resp events.AP
 	return
 }
 
+// chargeDaily charges a download by the client against
+// DAILY_DOWNLOAD_LIMIT, answering 429 and reporting false once the client
+// used up its day.
+func chargeDaily(ctx context.Context, resp *events.APIGatewayProxyResponse, req events.APIGatewayProxyRequest) (*dailyCharge, bool, error) {
+	charge, err := chargeDailyDownload(ctx, req.RequestContext.Identity.SourceIP)
+	if err == errQuotaExceeded {
+		resp.StatusCode = http.StatusTooManyRequests
+		resp.Headers = map[string]string{
+			"Retry-After": strconv.FormatInt(int64(time.Until(dailyDownloadEnd(time.Now()))/time.Second)+1, 10),
+		}
+		resp.Body = "daily download limit reached\n"
+		return nil, false, nil
+	}
+	if err != nil {
+		resp.StatusCode = http.StatusInternalServerError
+		return nil, false, err
+	}
+	return charge, true, nil
+}
+
+// attachment is the Content-Disposition of a download named filename.
+func attachment(filename string) string {
+	return fmt.Sprintf(`attachment; filename="%s"`, sanitizeFilename(filename))
+}
+
+// sendObject returns the file itself as the response body.
+func sendObject(resp *events.APIGatewayProxyResponse, item *transferItem, data []byte, disposition string) {
+	contentType := item.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	resp.StatusCode = http.StatusOK
+	resp.Headers = map[string]string{
+		"Content-Type":        contentType,
+		"Content-Disposition": disposition,
+		"Content-Length":      strconv.Itoa(len(data)),
+	}
+	resp.Body = base64.StdEncoding.EncodeToString(data)
+	resp.IsBase64Encoded = true
+}
+
+// sendURL redirects to url, or returns it in the body for raw requests.
+func sendURL(resp *events.APIGatewayProxyResponse, url string, raw bool) {
+	if raw {
+		resp.StatusCode = http.StatusOK
+		resp.Headers = map[string]string{
+			"Content-Type": "text/plain; charset=utf-8",
+		}
+		resp.Body = url
+		return
+	}
+
+	resp.StatusCode = http.StatusFound
+	resp.Headers = map[string]string{
+		"Location": url,
+	}
+}
+
 func main() {
-	lambda.Start(handleRequest)
+	if addr := os.Getenv("DEV_ADDR"); addr != "" {
+		log.Fatal(serveDev(addr))
+	}
+	lambda.Start(handleEvent)
 }
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+const testDomain = "https://transfer.test"
+
+// useTestStores swaps in the in-memory metadata store and an fs storage
+// under a temporary directory for the length of the test.
+func useTestStores(t *testing.T) (*memoryStore, *fsStorage) {
+	t.Helper()
+
+	oldMeta, oldObjects := meta, objects
+	st := newMemoryStore()
+	fs := &fsStorage{dir: t.TempDir(), baseURL: "https://files.test"}
+	meta, objects = st, fs
+	t.Cleanup(func() { meta, objects = oldMeta, oldObjects })
+
+	setString(t, &domain, testDomain)
+	return st, fs
+}
+
+// The set helpers change a configuration variable for the length of the
+// test.
+
+func setString(t *testing.T, p *string, v string) {
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
+
+func setBool(t *testing.T, p *bool, v bool) {
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
+
+func setInt(t *testing.T, p *int, v int) {
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
+
+func setInt64(t *testing.T, p *int64, v int64) {
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
+
+func setDuration(t *testing.T, p *time.Duration, v time.Duration) {
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
+
+// testRequest builds the event API Gateway sends for method on target, a
+// path with an optional query, the way the dev server does.
+func testRequest(method, target string, headers map[string]string, body string) events.APIGatewayProxyRequest {
+	u, err := url.Parse(target)
+	if err != nil {
+		panic(err)
+	}
+
+	req := events.APIGatewayProxyRequest{
+		Path:                  u.Path,
+		HTTPMethod:            method,
+		Headers:               map[string]string{},
+		QueryStringParameters: map[string]string{},
+		PathParameters: map[string]string{
+			"proxy": strings.TrimPrefix(u.Path, "/"),
+		},
+		Body:            base64.StdEncoding.EncodeToString([]byte(body)),
+		IsBase64Encoded: true,
+	}
+	req.RequestContext.HTTPMethod = method
+	req.RequestContext.Identity.SourceIP = "192.0.2.1"
+	for k, v := range headers {
+		req.Headers[k] = v
+	}
+	for k := range u.Query() {
+		req.QueryStringParameters[k] = u.Query().Get(k)
+	}
+	return req
+}
+
+// serve runs req through the handler, failing the test on an error.
+func serve(t *testing.T, req events.APIGatewayProxyRequest) events.APIGatewayProxyResponse {
+	t.Helper()
+
+	resp, err := handleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", req.HTTPMethod, req.Path, err)
+	}
+	return resp
+}
+
+// testUpload stores body as name and returns the path of its link and its
+// delete token.
+func testUpload(t *testing.T, name, body string, headers map[string]string) (string, string) {
+	t.Helper()
+
+	resp := serve(t, testRequest("PUT", "/"+name, headers, body))
+	if resp.StatusCode != 200 {
+		t.Fatalf("upload %s: %d %q", name, resp.StatusCode, resp.Body)
+	}
+	link := strings.SplitN(resp.Body, "\n", 2)[0]
+	return strings.TrimPrefix(link, testDomain), resp.Headers["X-Delete-Token"]
+}
+
+// keyOf is the key in the path of a download link.
+func keyOf(link string) string {
+	return strings.SplitN(strings.TrimPrefix(link, "/"), "/", 2)[0]
+}
+
+// responseBody decodes the body of resp.
+func responseBody(t *testing.T, resp events.APIGatewayProxyResponse) string {
+	t.Helper()
+
+	if !resp.IsBase64Encoded {
+		return resp.Body
+	}
+	b, err := base64.StdEncoding.DecodeString(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestRawURLCountsDownload(t *testing.T) {
+	useTestStores(t)
+	setInt(t, &maxDownloads, 2)
+
+	link, _ := testUpload(t, "a.txt", "hello", nil)
+	key := keyOf(link)
+
+	tests := []struct {
+		status int
+		left   string
+	}{
+		{http.StatusOK, "1"},
+		{http.StatusOK, "0"},
+		{http.StatusGone, "0"},
+	}
+	for i, tt := range tests {
+		resp := serve(t, testRequest("GET", "/url"+link, nil, ""))
+		if resp.StatusCode != tt.status {
+			t.Fatalf("download %d: %d, want %d", i, resp.StatusCode, tt.status)
+		}
+		if resp.Headers["X-Downloads-Remaining"] != tt.left {
+			t.Errorf("download %d: %s left, want %s", i, resp.Headers["X-Downloads-Remaining"], tt.left)
+		}
+		if tt.status == http.StatusOK && resp.Body != "https://files.test/"+key {
+			t.Errorf("download %d: body %q, want the storage url", i, resp.Body)
+		}
+	}
+
+	item, err := meta.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Times != 2 {
+		t.Errorf("counted %d downloads, want 2", item.Times)
+	}
+}
+
+func TestRedirectDownload(t *testing.T) {
+	useTestStores(t)
+
+	link, _ := testUpload(t, "a.txt", "hello", nil)
+	resp := serve(t, testRequest("GET", link, nil, ""))
+	if resp.StatusCode != http.StatusFound || resp.Headers["Location"] != "https://files.test/"+keyOf(link) {
+		t.Errorf("download: %d to %q", resp.StatusCode, resp.Headers["Location"])
+	}
+}
+
+func TestUploadDownloadFlow(t *testing.T) {
+	st, _ := useTestStores(t)
+	setString(t, &downloadMode, downloadProxy)
+	setInt(t, &maxDownloads, 2)
+
+	link, token := testUpload(t, "notes.txt", "hello, world", nil)
+	key := keyOf(link)
+	if _, ok := st.items[key]; !ok {
+		t.Fatalf("no record for %s", key)
+	}
+
+	tests := []struct {
+		method  string
+		headers map[string]string
+		status  int
+		body    string
+	}{
+		{"HEAD", nil, http.StatusOK, ""},
+		{"GET", nil, http.StatusOK, "hello, world"},
+		{"GET", nil, http.StatusOK, "hello, world"},
+		{"GET", nil, http.StatusGone, ""},
+		{"DELETE", map[string]string{"X-Delete-Token": "wrong"}, http.StatusForbidden, ""},
+		{"DELETE", map[string]string{"X-Delete-Token": token}, http.StatusNoContent, ""},
+		{"GET", nil, http.StatusNotFound, ""},
+	}
+	for i, tt := range tests {
+		resp := serve(t, testRequest(tt.method, link, tt.headers, ""))
+		if resp.StatusCode != tt.status {
+			t.Fatalf("step %d, %s: %d, want %d", i, tt.method, resp.StatusCode, tt.status)
+		}
+		if tt.body != "" && responseBody(t, resp) != tt.body {
+			t.Errorf("step %d: body %q, want %q", i, responseBody(t, resp), tt.body)
+		}
+	}
+	if _, ok := st.items[key]; ok {
+		t.Errorf("record of %s left after delete", key)
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct {
+		path, want string
+	}{
+		{"", ""},
+		{"/", ""},
+		{"key", "key"},
+		{"key/", "key"},
+		{"/key/a.txt", "key/a.txt"},
+		{"key//a.txt", "key/a.txt"},
+		{"//key///a.txt//", "key/a.txt"},
+		{"url//key/a.txt", "url/key/a.txt"},
+	}
+	for _, tt := range tests {
+		if got := cleanPath(tt.path); got != tt.want {
+			t.Errorf("cleanPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestSlashVariations(t *testing.T) {
+	useTestStores(t)
+	setInt(t, &maxDownloads, 100)
+
+	link, _ := testUpload(t, "a.txt/", "hello", nil)
+	if !strings.HasSuffix(link, "/a.txt") {
+		t.Fatalf("upload as a.txt/ linked as %s", link)
+	}
+	key := keyOf(link)
+
+	tests := []struct {
+		path     string
+		optional bool
+		want     int
+	}{
+		{"/" + key + "/a.txt", false, http.StatusFound},
+		{"//" + key + "/a.txt", false, http.StatusFound},
+		{"/" + key + "//a.txt", false, http.StatusFound},
+		{"/" + key + "/a.txt/", false, http.StatusFound},
+		{"/" + key + "///a.txt//", false, http.StatusFound},
+		{"/" + key + "/", false, http.StatusNotFound},
+		{"/" + key, false, http.StatusNotFound},
+		{"/" + key + "/", true, http.StatusFound},
+		{"/" + key + "//", true, http.StatusFound},
+		{"//", true, http.StatusNotFound},
+		{"/url//" + key + "/a.txt", false, http.StatusOK},
+	}
+	for _, tt := range tests {
+		setBool(t, &filenameOptional, tt.optional)
+
+		// set the path as is: url.Parse reads a leading "//" as a host
+		req := testRequest("GET", "/", nil, "")
+		req.Path, req.PathParameters["proxy"] = tt.path, strings.TrimPrefix(tt.path, "/")
+		if resp := serve(t, req); resp.StatusCode != tt.want {
+			t.Errorf("GET %s (optional %v): %d, want %d", tt.path, tt.optional, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+func TestObjectKeyFor(t *testing.T) {
+	at := time.Date(2024, 6, 1, 23, 30, 0, 0, time.FixedZone("", -2*3600))
+	tests := []struct {
+		layout, want string
+	}{
+		{"", ""},
+		{"2006/01/02", "2024/06/02/abcde"},
+		{"2006-01", "2024-06/abcde"},
+	}
+	for _, tt := range tests {
+		setString(t, &objectKeyLayout, tt.layout)
+		if got := objectKeyFor("abcde", at); got != tt.want {
+			t.Errorf("layout %q: %q, want %q", tt.layout, got, tt.want)
+		}
+	}
+
+	paths := []struct {
+		item transferItem
+		want string
+	}{
+		{transferItem{S3Key: "abcde"}, "abcde"},
+		{transferItem{S3Key: "abcde", ObjectKey: "2024/06/02/abcde"}, "2024/06/02/abcde"},
+	}
+	for _, tt := range paths {
+		if got := tt.item.ObjectPath(); got != tt.want {
+			t.Errorf("ObjectPath of %+v = %q, want %q", tt.item, got, tt.want)
+		}
+	}
+}
+
+func TestDatePrefixedUpload(t *testing.T) {
+	_, fs := useTestStores(t)
+	setString(t, &objectKeyLayout, "2006/01/02")
+	setString(t, &downloadMode, downloadProxy)
+
+	link, token := testUpload(t, "a.txt", "hello", nil)
+	key := keyOf(link)
+	item, err := meta.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Now().UTC().Format("2006/01/02") + "/" + key; item.ObjectKey != want {
+		t.Fatalf("object stored as %q, want %q", item.ObjectKey, want)
+	}
+	if link != "/"+key+"/a.txt" {
+		t.Errorf("link %s carries the prefix", link)
+	}
+
+	if resp := serve(t, testRequest("GET", link, nil, "")); resp.StatusCode != http.StatusOK || responseBody(t, resp) != "hello" {
+		t.Errorf("download: %d %q", resp.StatusCode, responseBody(t, resp))
+	}
+	if resp := serve(t, testRequest("DELETE", link, map[string]string{"X-Delete-Token": token}, "")); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete: %d", resp.StatusCode)
+	}
+	if ok, _ := fs.Exists(context.Background(), item.ObjectKey, ""); ok {
+		t.Error("prefixed object left after delete")
+	}
+}
+
+func TestKeyOnlyDownload(t *testing.T) {
+	useTestStores(t)
+	setInt(t, &maxDownloads, 100)
+	setString(t, &downloadMode, downloadProxy)
+
+	named, _ := testUpload(t, "report.pdf", "%PDF-1.4", nil)
+	piped, _ := testUpload(t, "-", "plain text", nil)
+	pipedKey := keyOf(piped)
+
+	tests := []struct {
+		path        string
+		optional    bool
+		status      int
+		disposition string
+	}{
+		{named, false, http.StatusOK, `attachment; filename="report.pdf"`},
+		{"/" + keyOf(named), false, http.StatusNotFound, ""},
+		{"/" + keyOf(named), true, http.StatusOK, `attachment; filename="report.pdf"`},
+		{"/" + keyOf(named) + "/", true, http.StatusOK, `attachment; filename="report.pdf"`},
+		{"/" + pipedKey, true, http.StatusOK, `attachment; filename="` + pipedKey + `.txt"`},
+		{piped, false, http.StatusOK, `attachment; filename="` + pipedKey + `.txt"`},
+	}
+	for _, tt := range tests {
+		setBool(t, &filenameOptional, tt.optional)
+		resp := serve(t, testRequest("GET", tt.path, nil, ""))
+		if resp.StatusCode != tt.status {
+			t.Errorf("GET %s (optional %v): %d, want %d", tt.path, tt.optional, resp.StatusCode, tt.status)
+			continue
+		}
+		if resp.Headers["Content-Disposition"] != tt.disposition {
+			t.Errorf("GET %s: disposition %q, want %q", tt.path, resp.Headers["Content-Disposition"], tt.disposition)
+		}
+	}
+}
+
+func TestUploadResponseFormats(t *testing.T) {
+	tests := []struct {
+		verbose bool
+		lines   int
+	}{
+		{false, 1},
+		{true, 3},
+	}
+	for _, tt := range tests {
+		useTestStores(t)
+		setBool(t, &verboseResponse, tt.verbose)
+
+		resp := serve(t, testRequest("PUT", "/a.txt", nil, "hello"))
+		lines := strings.Split(strings.TrimSuffix(resp.Body, "\n"), "\n")
+		if len(lines) != tt.lines {
+			t.Fatalf("verbose %v: %q", tt.verbose, resp.Body)
+		}
+		if !strings.HasPrefix(lines[0], testDomain+"/") || !strings.HasSuffix(lines[0], "/a.txt") {
+			t.Errorf("verbose %v: first line %q is not the url", tt.verbose, lines[0])
+		}
+		if !tt.verbose {
+			continue
+		}
+
+		key := keyOf(strings.TrimPrefix(lines[0], testDomain))
+		expires, err := time.Parse(http.TimeFormat, strings.TrimPrefix(lines[1], "Expires: "))
+		if err != nil || expires.Before(time.Now()) {
+			t.Errorf("expiry line %q: %v", lines[1], err)
+		}
+		want := "Delete: curl -X DELETE '" + testDomain + "/" + key + "?token=" + resp.Headers["X-Delete-Token"] + "'"
+		if lines[2] != want {
+			t.Errorf("delete line %q, want %q", lines[2], want)
+		}
+
+		// the printed command works
+		target := strings.TrimPrefix(strings.Trim(strings.TrimPrefix(lines[2], "Delete: curl -X DELETE "), "'"), testDomain)
+		if resp := serve(t, testRequest("DELETE", target, nil, "")); resp.StatusCode != http.StatusNoContent {
+			t.Errorf("printed delete command: %d", resp.StatusCode)
+		}
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	useTestStores(t)
+
+	tests := []struct {
+		method, path string
+		allow        string
+	}{
+		{"PATCH", "/abcde/a.txt", "GET, HEAD, PUT, DELETE"},
+		{"OPTIONS", "/abcde/a.txt", "GET, HEAD, PUT, DELETE"},
+		{"POST", "/abcde/a.txt", "GET, HEAD, PUT, DELETE"},
+		{"POST", "/info/abcde/a.txt", "GET, HEAD, PUT, DELETE"},
+		{"PATCH", "/delete", "GET, HEAD, PUT, POST, DELETE"},
+		{"PATCH", "//delete/", "GET, HEAD, PUT, POST, DELETE"},
+	}
+	for _, tt := range tests {
+		req := testRequest(tt.method, "/", nil, "")
+		req.Path, req.PathParameters["proxy"] = tt.path, strings.TrimPrefix(tt.path, "/")
+
+		resp := serve(t, req)
+		if resp.StatusCode != http.StatusMethodNotAllowed {
+			t.Errorf("%s %s: %d, want 405", tt.method, tt.path, resp.StatusCode)
+		}
+		if resp.Headers["Allow"] != tt.allow || resp.Body != "method not allowed\n" {
+			t.Errorf("%s %s: Allow %q, body %q", tt.method, tt.path, resp.Headers["Allow"], resp.Body)
+		}
+	}
+
+	// every method listed is routed
+	for _, method := range strings.Split("GET, HEAD, PUT, POST, DELETE", ", ") {
+		if resp := serve(t, testRequest(method, "/delete", nil, "[]")); resp.StatusCode == http.StatusMethodNotAllowed {
+			t.Errorf("%s /delete: 405", method)
+		}
+	}
+}
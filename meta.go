@@ -0,0 +1,613 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+var (
+	errKeyExists    = errors.New("key exists")
+	errLimitReached = errors.New("limit reached")
+	errNoIndex      = errors.New("no uploader index")
+)
+
+// metaStore holds the transfer records and the counters kept alongside
+// them. Counters share the key space with records, under keys that never
+// collide with a generated key.
+type metaStore interface {
+	// Reserve stores item, failing with errKeyExists when an unexpired
+	// record holds its key.
+	Reserve(ctx context.Context, item *transferItem) error
+
+	// Get returns the record of key, or errNotFound.
+	Get(ctx context.Context, key string) (*transferItem, error)
+
+	// Delete removes the record or counter under key.
+	Delete(ctx context.Context, key string) error
+
+	// SetString sets the string field of the record of key, failing with
+	// errNotFound when there is no record.
+	SetString(ctx context.Context, key, field, value string) error
+
+	// Unset removes the field from the record of key, if there is one.
+	Unset(ctx context.Context, key, field string) error
+
+	// CountDownload adds one to the download count of key, failing with
+	// errLimitReached when the record is missing or already has limit.
+	CountDownload(ctx context.Context, key string, limit int) error
+
+	// Claim sets the timestamp field of the record of key to now, unless it
+	// is already set to cutoff or later. It reports whether it did.
+	Claim(ctx context.Context, key, field string, now, cutoff int64) (bool, error)
+
+	// Incr adds deltas to the counters under key, creating them as needed
+	// and expiring them at expireAt. It fails with errLimitReached, changing
+	// nothing, when any counter would exceed its entry in limits.
+	Incr(ctx context.Context, key string, deltas, limits map[string]int64, expireAt int64) error
+
+	// Hold claims key for owner until the unix time until. It reports false
+	// when another owner holds key past now.
+	Hold(ctx context.Context, key, owner string, until, now int64) (bool, error)
+
+	// Counters returns the counters under key. Missing ones read as zero.
+	Counters(ctx context.Context, key string) (map[string]int64, error)
+
+	// Expiring returns the unexpired records that expire by before, leaving
+	// out the forwarders rotation leaves behind.
+	Expiring(ctx context.Context, before int64) ([]*transferItem, error)
+
+	// ByUploader returns the records of uploader, or errNoIndex when the
+	// store cannot look them up.
+	ByUploader(ctx context.Context, uploader string) ([]*transferItem, error)
+}
+
+// newMetaStore selects the store named by META_BACKEND.
+func newMetaStore(backend string) (metaStore, error) {
+	switch backend {
+	case "", "dynamodb":
+		return &dynamoStore{table: dynmoTable, uploaderIndex: uploaderIndex, shards: dynamoShards}, nil
+	case "memory":
+		return newMemoryStore(), nil
+	}
+	return nil, fmt.Errorf("unknown metadata backend %q", backend)
+}
+
+func isConditionFailed(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+func numberAttr(n int64) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(n, 10))}
+}
+
+// dynamoStore keeps records in one DynamoDB table keyed by s3key.
+//
+// With shards > 1 the partition key is salted with a hash bucket, as in
+// "7#a1b2c3d4e5", spreading hot counters over more partitions. The bucket
+// derives from the key, so lookups by key still resolve directly. The cost
+// falls on everything else: items read through a scan or an index carry
+// the salted key, records written before sharding was enabled (or under a
+// different shard count) are no longer found, and no query can range over
+// related keys.
+type dynamoStore struct {
+	table         string
+	uploaderIndex string
+	shards        int
+}
+
+// partitionKey is the s3key attribute stored for key.
+func (st *dynamoStore) partitionKey(key string) string {
+	if st.shards <= 1 {
+		return key
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return strconv.Itoa(int(h.Sum32()%uint32(st.shards))) + "#" + key
+}
+
+// userKey reverses partitionKey.
+func (st *dynamoStore) userKey(pk string) string {
+	if st.shards <= 1 {
+		return pk
+	}
+	if i := strings.Index(pk, "#"); i >= 0 {
+		return pk[i+1:]
+	}
+	return pk
+}
+
+func (st *dynamoStore) keyAttr(key string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"s3key": {
+			S: aws.String(st.partitionKey(key)),
+		},
+	}
+}
+
+// unmarshal decodes a record, restoring its user-facing key.
+func (st *dynamoStore) unmarshal(av map[string]*dynamodb.AttributeValue) (*transferItem, error) {
+	var item transferItem
+	if err := dynamodbattribute.UnmarshalMap(av, &item); err != nil {
+		return nil, err
+	}
+	item.S3Key = st.userKey(item.S3Key)
+	return &item, nil
+}
+
+func (st *dynamoStore) Reserve(ctx context.Context, item *transferItem) error {
+	av, err := dynamodbattribute.MarshalMap(item)
+	if err != nil {
+		return err
+	}
+	av["s3key"] = &dynamodb.AttributeValue{S: aws.String(st.partitionKey(item.S3Key))}
+
+	_, err = dynamodb.New(sess).PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		Item:                av,
+		TableName:           aws.String(st.table),
+		ConditionExpression: aws.String("attribute_not_exists(s3key) or expire_at < :now"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": numberAttr(time.Now().Unix()),
+		},
+	})
+	if isConditionFailed(err) {
+		return errKeyExists
+	}
+	return err
+}
+
+func (st *dynamoStore) Get(ctx context.Context, key string) (*transferItem, error) {
+	out, err := dynamodb.New(sess).GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		Key:       st.keyAttr(key),
+		TableName: aws.String(st.table),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, errNotFound
+	}
+	return st.unmarshal(out.Item)
+}
+
+func (st *dynamoStore) Delete(ctx context.Context, key string) error {
+	_, err := dynamodb.New(sess).DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		Key:       st.keyAttr(key),
+		TableName: aws.String(st.table),
+	})
+	return err
+}
+
+func (st *dynamoStore) SetString(ctx context.Context, key, field, value string) error {
+	_, err := dynamodb.New(sess).UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		Key:                      st.keyAttr(key),
+		TableName:                aws.String(st.table),
+		UpdateExpression:         aws.String("SET #f = :v"),
+		ConditionExpression:      aws.String("attribute_exists(s3key)"),
+		ExpressionAttributeNames: map[string]*string{"#f": aws.String(field)},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":v": {S: aws.String(value)},
+		},
+	})
+	if isConditionFailed(err) {
+		return errNotFound
+	}
+	return err
+}
+
+func (st *dynamoStore) Unset(ctx context.Context, key, field string) error {
+	_, err := dynamodb.New(sess).UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		Key:                      st.keyAttr(key),
+		TableName:                aws.String(st.table),
+		UpdateExpression:         aws.String("REMOVE #f"),
+		ConditionExpression:      aws.String("attribute_exists(s3key)"),
+		ExpressionAttributeNames: map[string]*string{"#f": aws.String(field)},
+	})
+	if isConditionFailed(err) {
+		return nil
+	}
+	return err
+}
+
+func (st *dynamoStore) CountDownload(ctx context.Context, key string, limit int) error {
+	_, err := dynamodb.New(sess).UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		Key:                 st.keyAttr(key),
+		TableName:           aws.String(st.table),
+		ReturnValues:        aws.String("NONE"),
+		UpdateExpression:    aws.String("ADD times :one"),
+		ConditionExpression: aws.String("attribute_exists(s3key) and (attribute_not_exists(times) or times < :limit)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one":   numberAttr(1),
+			":limit": numberAttr(int64(limit)),
+		},
+	})
+	if isConditionFailed(err) {
+		return errLimitReached
+	}
+	return err
+}
+
+func (st *dynamoStore) Claim(ctx context.Context, key, field string, now, cutoff int64) (bool, error) {
+	_, err := dynamodb.New(sess).UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		Key:                      st.keyAttr(key),
+		TableName:                aws.String(st.table),
+		UpdateExpression:         aws.String("SET #f = :now"),
+		ConditionExpression:      aws.String("attribute_exists(s3key) and (attribute_not_exists(#f) or #f < :cutoff)"),
+		ExpressionAttributeNames: map[string]*string{"#f": aws.String(field)},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now":    numberAttr(now),
+			":cutoff": numberAttr(cutoff),
+		},
+	})
+	if isConditionFailed(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (st *dynamoStore) Incr(ctx context.Context, key string, deltas, limits map[string]int64, expireAt int64) error {
+	var (
+		adds  []string
+		conds []string
+		names = map[string]*string{}
+		vals  = map[string]*dynamodb.AttributeValue{
+			":exp": numberAttr(expireAt),
+		}
+	)
+
+	i := 0
+	for field, delta := range deltas {
+		n, v := fmt.Sprintf("#f%d", i), fmt.Sprintf(":d%d", i)
+		names[n] = aws.String(field)
+		vals[v] = numberAttr(delta)
+		adds = append(adds, n+" "+v)
+
+		if limit, ok := limits[field]; ok {
+			if delta > limit {
+				return errLimitReached
+			}
+			r := fmt.Sprintf(":r%d", i)
+			vals[r] = numberAttr(limit - delta)
+			conds = append(conds, fmt.Sprintf("(attribute_not_exists(%s) or %s <= %s)", n, n, r))
+		}
+		i++
+	}
+
+	in := &dynamodb.UpdateItemInput{
+		Key:                       st.keyAttr(key),
+		TableName:                 aws.String(st.table),
+		UpdateExpression:          aws.String("ADD " + strings.Join(adds, ", ") + " SET expire_at = :exp"),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: vals,
+	}
+	if len(conds) > 0 {
+		in.ConditionExpression = aws.String(strings.Join(conds, " and "))
+	}
+
+	_, err := dynamodb.New(sess).UpdateItemWithContext(ctx, in)
+	if isConditionFailed(err) {
+		return errLimitReached
+	}
+	return err
+}
+
+func (st *dynamoStore) Hold(ctx context.Context, key, owner string, until, now int64) (bool, error) {
+	_, err := dynamodb.New(sess).PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		Item: map[string]*dynamodb.AttributeValue{
+			"s3key":     {S: aws.String(st.partitionKey(key))},
+			"owner":     {S: aws.String(owner)},
+			"expire_at": numberAttr(until),
+		},
+		TableName:           aws.String(st.table),
+		ConditionExpression: aws.String("attribute_not_exists(s3key) or #owner = :owner or expire_at < :now"),
+		ExpressionAttributeNames: map[string]*string{
+			"#owner": aws.String("owner"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":owner": {S: aws.String(owner)},
+			":now":   numberAttr(now),
+		},
+	})
+	if isConditionFailed(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (st *dynamoStore) Counters(ctx context.Context, key string) (map[string]int64, error) {
+	out, err := dynamodb.New(sess).GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		Key:       st.keyAttr(key),
+		TableName: aws.String(st.table),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return numbers(out.Item), nil
+}
+
+func (st *dynamoStore) ByUploader(ctx context.Context, uploader string) ([]*transferItem, error) {
+	if st.uploaderIndex == "" {
+		return nil, errNoIndex
+	}
+
+	var (
+		items []*transferItem
+		uerr  error
+	)
+	in := &dynamodb.QueryInput{
+		TableName:              aws.String(st.table),
+		IndexName:              aws.String(st.uploaderIndex),
+		KeyConditionExpression: aws.String("uploader = :id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":id": {S: aws.String(uploader)},
+		},
+	}
+	err := dynamodb.New(sess).QueryPagesWithContext(ctx, in, func(out *dynamodb.QueryOutput, last bool) bool {
+		for _, av := range out.Items {
+			var item *transferItem
+			if item, uerr = st.unmarshal(av); uerr != nil {
+				return false
+			}
+			items = append(items, item)
+		}
+		return true
+	})
+	if err == nil {
+		err = uerr
+	}
+	return items, err
+}
+
+func (st *dynamoStore) Expiring(ctx context.Context, before int64) ([]*transferItem, error) {
+	var (
+		items []*transferItem
+		uerr  error
+	)
+	in := &dynamodb.ScanInput{
+		TableName:        aws.String(st.table),
+		FilterExpression: aws.String("attribute_exists(filename) and attribute_not_exists(rotated_to) and expire_at between :now and :before"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now":    numberAttr(time.Now().Unix()),
+			":before": numberAttr(before),
+		},
+	}
+	err := dynamodb.New(sess).ScanPagesWithContext(ctx, in, func(out *dynamodb.ScanOutput, last bool) bool {
+		for _, av := range out.Items {
+			var item *transferItem
+			if item, uerr = st.unmarshal(av); uerr != nil {
+				return false
+			}
+			items = append(items, item)
+		}
+		return true
+	})
+	if err == nil {
+		err = uerr
+	}
+	return items, err
+}
+
+// numbers collects the numeric attributes of av.
+func numbers(av map[string]*dynamodb.AttributeValue) map[string]int64 {
+	m := map[string]int64{}
+	for k, v := range av {
+		if v.N != nil {
+			m[k], _ = strconv.ParseInt(*v.N, 10, 64)
+		}
+	}
+	return m
+}
+
+// memoryStore keeps everything in process, for local development. It holds
+// records in their DynamoDB form so that both stores agree on attributes.
+type memoryStore struct {
+	mu    sync.Mutex
+	items map[string]map[string]*dynamodb.AttributeValue
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{items: map[string]map[string]*dynamodb.AttributeValue{}}
+}
+
+func (st *memoryStore) Reserve(ctx context.Context, item *transferItem) error {
+	av, err := dynamodbattribute.MarshalMap(item)
+	if err != nil {
+		return err
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if cur, ok := st.items[item.S3Key]; ok {
+		if exp, ok := numbers(cur)["expire_at"]; !ok || exp >= time.Now().Unix() {
+			return errKeyExists
+		}
+	}
+	st.items[item.S3Key] = av
+	return nil
+}
+
+func (st *memoryStore) Get(ctx context.Context, key string) (*transferItem, error) {
+	st.mu.Lock()
+	av, ok := st.items[key]
+	st.mu.Unlock()
+
+	if !ok {
+		return nil, errNotFound
+	}
+
+	var item transferItem
+	if err := dynamodbattribute.UnmarshalMap(av, &item); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+func (st *memoryStore) Delete(ctx context.Context, key string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	delete(st.items, key)
+	return nil
+}
+
+func (st *memoryStore) SetString(ctx context.Context, key, field, value string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	av, ok := st.items[key]
+	if !ok {
+		return errNotFound
+	}
+	av[field] = &dynamodb.AttributeValue{S: aws.String(value)}
+	return nil
+}
+
+func (st *memoryStore) Unset(ctx context.Context, key, field string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if av, ok := st.items[key]; ok {
+		delete(av, field)
+	}
+	return nil
+}
+
+func (st *memoryStore) CountDownload(ctx context.Context, key string, limit int) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	av, ok := st.items[key]
+	if !ok {
+		return errLimitReached
+	}
+
+	times := numbers(av)["times"]
+	if times >= int64(limit) {
+		return errLimitReached
+	}
+	av["times"] = numberAttr(times + 1)
+	return nil
+}
+
+func (st *memoryStore) Claim(ctx context.Context, key, field string, now, cutoff int64) (bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	av, ok := st.items[key]
+	if !ok {
+		return false, nil
+	}
+	if v, ok := numbers(av)[field]; ok && v >= cutoff {
+		return false, nil
+	}
+	av[field] = numberAttr(now)
+	return true, nil
+}
+
+func (st *memoryStore) Incr(ctx context.Context, key string, deltas, limits map[string]int64, expireAt int64) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	av, ok := st.items[key]
+	if !ok {
+		av = map[string]*dynamodb.AttributeValue{
+			"s3key": {S: aws.String(key)},
+		}
+	}
+
+	cur := numbers(av)
+	for field, delta := range deltas {
+		if limit, ok := limits[field]; ok && cur[field]+delta > limit {
+			return errLimitReached
+		}
+	}
+
+	for field, delta := range deltas {
+		av[field] = numberAttr(cur[field] + delta)
+	}
+	av["expire_at"] = numberAttr(expireAt)
+	st.items[key] = av
+	return nil
+}
+
+func (st *memoryStore) Hold(ctx context.Context, key, owner string, until, now int64) (bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if cur, ok := st.items[key]; ok && numbers(cur)["expire_at"] >= now {
+		if v, ok := cur["owner"]; !ok || aws.StringValue(v.S) != owner {
+			return false, nil
+		}
+	}
+	st.items[key] = map[string]*dynamodb.AttributeValue{
+		"s3key":     {S: aws.String(key)},
+		"owner":     {S: aws.String(owner)},
+		"expire_at": numberAttr(until),
+	}
+	return true, nil
+}
+
+func (st *memoryStore) Counters(ctx context.Context, key string) (map[string]int64, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return numbers(st.items[key]), nil
+}
+
+func (st *memoryStore) ByUploader(ctx context.Context, uploader string) ([]*transferItem, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	var items []*transferItem
+	for _, av := range st.items {
+		if v, ok := av["uploader"]; !ok || aws.StringValue(v.S) != uploader {
+			continue
+		}
+
+		var item transferItem
+		if err := dynamodbattribute.UnmarshalMap(av, &item); err != nil {
+			return nil, err
+		}
+		items = append(items, &item)
+	}
+	return items, nil
+}
+
+func (st *memoryStore) Expiring(ctx context.Context, before int64) ([]*transferItem, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	var (
+		items []*transferItem
+		now   = time.Now().Unix()
+	)
+	for _, av := range st.items {
+		exp, ok := numbers(av)["expire_at"]
+		if _, named := av["filename"]; !named || !ok || exp < now || exp > before {
+			continue
+		}
+		if _, rotated := av["rotated_to"]; rotated {
+			continue
+		}
+
+		var item transferItem
+		if err := dynamodbattribute.UnmarshalMap(av, &item); err != nil {
+			return nil, err
+		}
+		items = append(items, &item)
+	}
+	return items, nil
+}
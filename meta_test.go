@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestNewMetaStore(t *testing.T) {
+	tests := []struct {
+		backend string
+		want    string
+	}{
+		{"", "*main.dynamoStore"},
+		{"dynamodb", "*main.dynamoStore"},
+		{"memory", "*main.memoryStore"},
+		{"sqlite", ""},
+	}
+	for _, tt := range tests {
+		st, err := newMetaStore(tt.backend)
+		if got := fmt.Sprintf("%T", st); tt.want == "" && err == nil || tt.want != "" && got != tt.want {
+			t.Errorf("backend %q: %s, %v", tt.backend, got, err)
+		}
+	}
+}
+
+func TestMemoryReserve(t *testing.T) {
+	ctx := context.Background()
+	st := newMemoryStore()
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name     string
+		expireAt int64
+		err      error
+	}{
+		{"new key", now + 60, nil},
+		{"live record", now + 60, errKeyExists},
+	}
+	for _, tt := range tests {
+		if err := st.Reserve(ctx, &transferItem{S3Key: "abcde", ExpireAt: tt.expireAt}); err != tt.err {
+			t.Errorf("%s: %v, want %v", tt.name, err, tt.err)
+		}
+	}
+
+	// an expired record the TTL sweep has not removed yet gives up its key
+	st.items["old"] = map[string]*dynamodb.AttributeValue{"s3key": {S: aws.String("old")}, "expire_at": numberAttr(now - 1)}
+	if err := st.Reserve(ctx, &transferItem{S3Key: "old", ExpireAt: now + 60}); err != nil {
+		t.Errorf("expired record: %v", err)
+	}
+	// a counter has no expiry and is never taken over
+	st.items["counter"] = map[string]*dynamodb.AttributeValue{"s3key": {S: aws.String("counter")}}
+	if err := st.Reserve(ctx, &transferItem{S3Key: "counter"}); err != errKeyExists {
+		t.Errorf("counter: %v, want errKeyExists", err)
+	}
+}
+
+func TestMemoryCountDownload(t *testing.T) {
+	ctx := context.Background()
+	st := newMemoryStore()
+	st.Reserve(ctx, &transferItem{S3Key: "abcde", ExpireAt: time.Now().Unix() + 60})
+
+	tests := []struct {
+		key   string
+		limit int
+		err   error
+	}{
+		{"abcde", 2, nil},
+		{"abcde", 2, nil},
+		{"abcde", 2, errLimitReached},
+		{"abcde", 3, nil},
+		{"missing", 3, errLimitReached},
+	}
+	for i, tt := range tests {
+		if err := st.CountDownload(ctx, tt.key, tt.limit); err != tt.err {
+			t.Errorf("count %d of %s: %v, want %v", i, tt.key, err, tt.err)
+		}
+	}
+	if item, _ := st.Get(ctx, "abcde"); item.Times != 3 {
+		t.Errorf("counted %d, want 3", item.Times)
+	}
+}
+
+func TestMemoryIncr(t *testing.T) {
+	ctx := context.Background()
+	st := newMemoryStore()
+	limits := map[string]int64{"n": 2, "bytes": 10}
+
+	tests := []struct {
+		n, bytes int64
+		err      error
+	}{
+		{1, 4, nil},
+		{1, 7, errLimitReached},
+		{1, 6, nil},
+		{1, 0, errLimitReached},
+	}
+	for i, tt := range tests {
+		err := st.Incr(ctx, "quota#x", map[string]int64{"n": tt.n, "bytes": tt.bytes}, limits, 99)
+		if err != tt.err {
+			t.Errorf("incr %d: %v, want %v", i, err, tt.err)
+		}
+	}
+
+	got, _ := st.Counters(ctx, "quota#x")
+	if got["n"] != 2 || got["bytes"] != 10 || got["expire_at"] != 99 {
+		t.Errorf("counters %v", got)
+	}
+	if got, _ := st.Counters(ctx, "quota#none"); len(got) != 0 {
+		t.Errorf("missing counters %v", got)
+	}
+}
+
+func TestMemoryClaimAndHold(t *testing.T) {
+	ctx := context.Background()
+	st := newMemoryStore()
+	st.Reserve(ctx, &transferItem{S3Key: "abcde", ExpireAt: 1 << 40})
+
+	claims := []struct {
+		now, cutoff int64
+		want        bool
+	}{
+		{100, 50, true},
+		{120, 50, false},
+		{200, 150, true},
+	}
+	for _, tt := range claims {
+		if ok, err := st.Claim(ctx, "abcde", "notified_at", tt.now, tt.cutoff); ok != tt.want || err != nil {
+			t.Errorf("claim at %d: %v, %v", tt.now, ok, err)
+		}
+	}
+	if ok, _ := st.Claim(ctx, "missing", "notified_at", 1, 0); ok {
+		t.Error("claimed a missing record")
+	}
+
+	holds := []struct {
+		owner      string
+		until, now int64
+		want       bool
+	}{
+		{"a", 100, 10, true},
+		{"b", 200, 50, false},
+		{"a", 300, 50, true},
+		{"b", 400, 301, true},
+	}
+	for _, tt := range holds {
+		if ok, err := st.Hold(ctx, "slug#x", tt.owner, tt.until, tt.now); ok != tt.want || err != nil {
+			t.Errorf("hold by %s at %d: %v, %v", tt.owner, tt.now, ok, err)
+		}
+	}
+}
+
+// useTestDynamo points the AWS session at a fake DynamoDB that keeps items
+// by their s3key attribute, ignoring conditions, and returns its table.
+func useTestDynamo(t *testing.T) map[string]map[string]*dynamodb.AttributeValue {
+	t.Helper()
+
+	var (
+		table = map[string]map[string]*dynamodb.AttributeValue{}
+		calls *[]s3Call
+	)
+	_, calls = useTestS3(t, func(w http.ResponseWriter, r *http.Request) {
+		var in struct {
+			Item map[string]*dynamodb.AttributeValue
+			Key  map[string]*dynamodb.AttributeValue
+		}
+		// useTestS3 has read the body already
+		body := (*calls)[len(*calls)-1].body
+		if err := json.Unmarshal([]byte(body), &in); err != nil {
+			t.Errorf("fake dynamodb: %v", err)
+		}
+
+		out := map[string]interface{}{}
+		switch op := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810."); op {
+		case "PutItem":
+			table[aws.StringValue(in.Item["s3key"].S)] = in.Item
+		case "GetItem":
+			if item, ok := table[aws.StringValue(in.Key["s3key"].S)]; ok {
+				out["Item"] = item
+			}
+		case "DeleteItem":
+			delete(table, aws.StringValue(in.Key["s3key"].S))
+		default:
+			t.Errorf("fake dynamodb: unexpected %s", op)
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		json.NewEncoder(w).Encode(out)
+	})
+	return table
+}
+
+func TestPartitionKey(t *testing.T) {
+	tests := []struct {
+		shards int
+		key    string
+	}{
+		{0, "abcde"},
+		{1, "abcde"},
+		{8, "abcde"},
+		{8, "my-slug"},
+		{8, "slug#my-slug"},
+		{16, "quota#ip:192.0.2.1#1700000000"},
+	}
+	for _, tt := range tests {
+		st := &dynamoStore{shards: tt.shards}
+		pk := st.partitionKey(tt.key)
+		if tt.shards <= 1 && pk != tt.key {
+			t.Errorf("%d shards: %q salted as %q", tt.shards, tt.key, pk)
+		}
+		if tt.shards > 1 && !strings.HasSuffix(pk, "#"+tt.key) {
+			t.Errorf("%d shards: %q salted as %q", tt.shards, tt.key, pk)
+		}
+		if pk != st.partitionKey(tt.key) {
+			t.Errorf("%d shards: %q salted differently twice", tt.shards, tt.key)
+		}
+		if got := st.userKey(pk); got != tt.key {
+			t.Errorf("%d shards: userKey(%q) = %q, want %q", tt.shards, pk, got, tt.key)
+		}
+	}
+
+	// the buckets spread keys rather than piling them into one
+	st := &dynamoStore{shards: 8}
+	buckets := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		pk := st.partitionKey(fmt.Sprintf("key%d", i))
+		buckets[pk[:strings.Index(pk, "#")]] = true
+	}
+	if len(buckets) < 6 {
+		t.Errorf("100 keys fell into %d of 8 buckets", len(buckets))
+	}
+}
+
+func TestShardedLookup(t *testing.T) {
+	ctx := context.Background()
+	table := useTestDynamo(t)
+
+	for _, shards := range []int{1, 4, 16} {
+		st := &dynamoStore{table: "transfers", shards: shards}
+		for _, key := range []string{"abcde", "my-slug"} {
+			if err := st.Reserve(ctx, &transferItem{S3Key: key, Filename: "a.txt"}); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := table[st.partitionKey(key)]; !ok {
+				t.Errorf("%d shards: %s not stored under %s", shards, key, st.partitionKey(key))
+			}
+
+			item, err := st.Get(ctx, key)
+			if err != nil || item.S3Key != key || item.Filename != "a.txt" {
+				t.Errorf("%d shards: Get(%s) = %+v, %v", shards, key, item, err)
+			}
+
+			if err := st.Delete(ctx, key); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := st.Get(ctx, key); err != errNotFound {
+				t.Errorf("%d shards: Get(%s) after delete: %v", shards, key, err)
+			}
+		}
+	}
+}
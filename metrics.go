@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// emfMetadata is the _aws member of a CloudWatch embedded metric format
+// record. Lambda ships the record from the function log and CloudWatch
+// extracts the metrics it declares, so the percentiles of UploadSize give
+// the distribution of upload sizes.
+type emfMetadata struct {
+	Timestamp         int64          `json:"Timestamp"`
+	CloudWatchMetrics []emfDirective `json:"CloudWatchMetrics"`
+}
+
+type emfDirective struct {
+	Namespace  string      `json:"Namespace"`
+	Dimensions [][]string  `json:"Dimensions"`
+	Metrics    []emfMetric `json:"Metrics"`
+}
+
+type emfMetric struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit"`
+}
+
+// recordUploadSize emits the size of an upload under METRICS_NAMESPACE,
+// dimensioned by whether the upload succeeded.
+func recordUploadSize(size int64, ok bool) {
+	if metricsNamespace == "" {
+		return
+	}
+
+	result := "failure"
+	if ok {
+		result = "success"
+	}
+
+	b, err := json.Marshal(struct {
+		AWS        emfMetadata `json:"_aws"`
+		Result     string      `json:"Result"`
+		UploadSize int64       `json:"UploadSize"`
+	}{
+		AWS: emfMetadata{
+			Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+			CloudWatchMetrics: []emfDirective{{
+				Namespace:  metricsNamespace,
+				Dimensions: [][]string{{"Result"}},
+				Metrics:    []emfMetric{{Name: "UploadSize", Unit: "Bytes"}},
+			}},
+		},
+		Result:     result,
+		UploadSize: size,
+	})
+	if err != nil {
+		log.Printf("metric UploadSize: %v", err)
+		return
+	}
+	// the record must be a line of its own, without the log prefix
+	fmt.Println(string(b))
+}
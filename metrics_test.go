@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+// captureStdout returns what f prints to standard output.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = old }()
+
+	f()
+	w.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+type emfRecord struct {
+	AWS        emfMetadata `json:"_aws"`
+	Result     string
+	UploadSize int64
+}
+
+// emfRecords decodes the metric records among the lines of out.
+func emfRecords(t *testing.T, out string) []emfRecord {
+	t.Helper()
+
+	var records []emfRecord
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		if !strings.HasPrefix(sc.Text(), `{"_aws"`) {
+			continue
+		}
+		var rec emfRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatalf("record %s: %v", sc.Text(), err)
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+func TestUploadSizeMetric(t *testing.T) {
+	useTestStores(t)
+	setString(t, &metricsNamespace, "transfer.test")
+	useAllowedExtensions(t, ".txt")
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		result string
+	}{
+		{"a.txt", "hello", http.StatusOK, "success"},
+		{"b.txt", strings.Repeat("x", 4096), http.StatusOK, "success"},
+		{"c.png", "refused", http.StatusUnsupportedMediaType, "failure"},
+	}
+	for _, tt := range tests {
+		var resp int
+		out := captureStdout(t, func() {
+			resp = serve(t, testRequest("PUT", "/"+tt.name, nil, tt.body)).StatusCode
+		})
+		if resp != tt.status {
+			t.Fatalf("upload %s: %d, want %d", tt.name, resp, tt.status)
+		}
+
+		records := emfRecords(t, out)
+		if len(records) != 1 {
+			t.Fatalf("upload %s: %d records in %q", tt.name, len(records), out)
+		}
+		rec := records[0]
+		if rec.UploadSize != int64(len(tt.body)) || rec.Result != tt.result {
+			t.Errorf("upload %s: %d bytes, %s", tt.name, rec.UploadSize, rec.Result)
+		}
+		if d := rec.AWS.CloudWatchMetrics; len(d) != 1 || d[0].Namespace != "transfer.test" || d[0].Metrics[0] != (emfMetric{"UploadSize", "Bytes"}) {
+			t.Errorf("upload %s: directives %+v", tt.name, d)
+		}
+	}
+}
+
+func TestUploadSizeMetricOff(t *testing.T) {
+	setString(t, &metricsNamespace, "")
+	if out := captureStdout(t, func() { recordUploadSize(5, true) }); out != "" {
+		t.Errorf("emitted %q without METRICS_NAMESPACE", out)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ses"
+)
+
+// notifyDownload emails the uploader of item that ip downloaded it. Failures
+// are logged rather than failing the download.
+func notifyDownload(ctx context.Context, item *transferItem, ip string) {
+	now := time.Now()
+
+	ok, err := meta.Claim(ctx, item.S3Key, "notified_at", now.Unix(), now.Add(-notifyInterval).Unix())
+	if err != nil {
+		log.Printf("notify %s: %v", item.S3Key, err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	body := fmt.Sprintf("%s/%s/%s was downloaded from %s at %s.\n",
+		domain, item.S3Key, item.Filename, ip, now.UTC().Format(time.RFC1123))
+
+	_, err = ses.New(sess).SendEmailWithContext(ctx, &ses.SendEmailInput{
+		Source: aws.String(sesSender),
+		Destination: &ses.Destination{
+			ToAddresses: []*string{aws.String(item.NotifyEmail)},
+		},
+		Message: &ses.Message{
+			Subject: &ses.Content{
+				Data: aws.String("Your file " + item.Filename + " was downloaded"),
+			},
+			Body: &ses.Body{
+				Text: &ses.Content{
+					Data: aws.String(body),
+				},
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("notify %s: %v", item.S3Key, err)
+	}
+}
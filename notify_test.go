@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDownloadNotification(t *testing.T) {
+	tests := []struct {
+		name     string
+		email    string
+		sender   string
+		interval time.Duration
+		mails    int
+	}{
+		{"not asked", "", "from@transfer.test", time.Hour, 0},
+		{"no sender", "me@example.com", "", time.Hour, 0},
+		{"rate limited", "me@example.com", "from@transfer.test", time.Hour, 1},
+	}
+	for _, tt := range tests {
+		useTestStores(t)
+		_, calls := useTestS3(t, ok)
+		setString(t, &sesSender, tt.sender)
+		setDuration(t, &notifyInterval, tt.interval)
+
+		var headers map[string]string
+		if tt.email != "" {
+			headers = map[string]string{"X-Notify-On-Download": tt.email}
+		}
+		link, _ := testUpload(t, "a.txt", "hello", headers)
+		for i := 0; i < 3; i++ {
+			if resp := serve(t, testRequest("GET", link, nil, "")); resp.StatusCode != http.StatusFound {
+				t.Fatalf("%s: download %d: %d", tt.name, i, resp.StatusCode)
+			}
+		}
+
+		var mails []url.Values
+		for _, c := range *calls {
+			if form, _ := url.ParseQuery(c.body); form.Get("Action") == "SendEmail" {
+				mails = append(mails, form)
+			}
+		}
+		if len(mails) != tt.mails {
+			t.Errorf("%s: sent %d mails, want %d", tt.name, len(mails), tt.mails)
+			continue
+		}
+		for _, form := range mails {
+			if form.Get("Destination.ToAddresses.member.1") != tt.email || form.Get("Source") != tt.sender {
+				t.Errorf("%s: mailed %v", tt.name, form)
+			}
+			if body := form.Get("Message.Body.Text.Data"); !strings.Contains(body, "192.0.2.1") {
+				t.Errorf("%s: body %q does not name the downloader", tt.name, body)
+			}
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Preview elements of the download page.
+const (
+	previewImage = "img"
+	previewFrame = "iframe"
+	previewText  = "pre"
+)
+
+// previewTextMax is how much of a text upload the page shows.
+const previewTextMax = 64 << 10
+
+// A counted page view lets its preview and its download link fetch the
+// file once each, for pageTokenTTL.
+const (
+	pageTokenLoads = 2
+	pageTokenTTL   = 10 * time.Minute
+)
+
+// defaultPreviewKinds maps content types to the element that previews them
+// on the download page, unless PREVIEW_KINDS says otherwise. A "type/*"
+// entry covers the whole type.
+var defaultPreviewKinds = map[string]string{
+	"image/*":         previewImage,
+	"application/pdf": previewFrame,
+	"text/*":          previewText,
+}
+
+var downloadPageTemplate = template.Must(template.New("download").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Filename}}</title>
+</head>
+<body>
+<h1>{{.Filename}}</h1>
+{{if eq .Kind "img"}}<p><img src="{{.InlineURL}}" alt="{{.Filename}}"></p>
+{{else if eq .Kind "iframe"}}<p><iframe src="{{.InlineURL}}" title="{{.Filename}}" sandbox></iframe></p>
+{{else if eq .Kind "pre"}}<pre>{{.Text}}</pre>
+{{end}}<p><a href="{{.DownloadURL}}" download>Download {{.Filename}}</a></p>
+</body>
+</html>
+`))
+
+// parsePreviewKinds reads PREVIEW_KINDS entries of the form "type=element".
+func parsePreviewKinds(entries []string) (map[string]string, error) {
+	kinds := map[string]string{}
+	for _, e := range entries {
+		parts := strings.SplitN(e, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid entry %q", e)
+		}
+		kind := strings.TrimSpace(parts[1])
+		switch kind {
+		case previewImage, previewFrame, previewText:
+		default:
+			return nil, fmt.Errorf("unknown preview element %q", kind)
+		}
+		kinds[strings.ToLower(strings.TrimSpace(parts[0]))] = kind
+	}
+	return kinds, nil
+}
+
+// previewKind picks the preview element for item, or "" for a download
+// button alone. Images and frames load the upload inline, so they are only
+// used for types that are safe to render in place. An upload behind an
+// X-Password gets no preview: the page only takes the view password, and
+// a preview would show the content without the download one.
+func previewKind(item *transferItem) string {
+	if item.PasswordHash != "" {
+		return ""
+	}
+	mt := mediaType(item.ContentType)
+	kind, ok := previewKinds[mt]
+	if !ok {
+		kind = previewKinds[strings.SplitN(mt, "/", 2)[0]+"/*"]
+	}
+	if (kind == previewImage || kind == previewFrame) && !previewable(item) {
+		return ""
+	}
+	return kind
+}
+
+// wantsPage reports whether a GET should see the download page: a browser
+// asking for the link itself, not for the file through ?inline or
+// ?download.
+func wantsPage(req events.APIGatewayProxyRequest) bool {
+	if !downloadPage || !acceptsHTML(req) {
+		return false
+	}
+	q := req.QueryStringParameters
+	return q["inline"] == "" && q["download"] == ""
+}
+
+// pageTokenKey names the record of a download page token. It shares the
+// transfer table, but never collides with a hex key.
+func pageTokenKey(s3key, token string) string {
+	return "page#" + s3key + "#" + token
+}
+
+// countsPage reports whether showing the download page of item counts as
+// a download: whenever it previews the upload, which public uploads are
+// never counted for.
+func countsPage(item *transferItem) bool {
+	return previewKind(item) != "" && !item.Public
+}
+
+// countPageView counts a view of the download page of item as a single
+// download, and returns the token that lets the page's own preview and
+// download links fetch the file without counting again. Reloading the page
+// counts again, so a preview cannot be seen more often than the upload can
+// be downloaded.
+func countPageView(ctx context.Context, req events.APIGatewayProxyRequest, item *transferItem) (string, error) {
+	if err := meta.CountDownload(ctx, item.S3Key, item.DownloadLimit()); err != nil {
+		return "", err
+	}
+	item.Times++
+	if item.NotifyEmail != "" {
+		notifyDownload(ctx, item, req.RequestContext.Identity.SourceIP)
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+
+	expireAt := time.Now().Add(pageTokenTTL).Unix()
+	err := meta.Incr(ctx, pageTokenKey(item.S3Key, token), map[string]int64{"loads": 0}, nil, expireAt)
+	return token, err
+}
+
+// usePageToken spends one of the pageTokenLoads fetches token grants on
+// item, reporting false once they are used up or the token expired.
+func usePageToken(ctx context.Context, item *transferItem, token string) (bool, error) {
+	key := pageTokenKey(item.S3Key, token)
+	counters, err := meta.Counters(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	expireAt := counters["expire_at"]
+	if expireAt < time.Now().Unix() {
+		return false, nil
+	}
+
+	err = meta.Incr(ctx, key, map[string]int64{"loads": 1}, map[string]int64{"loads": pageTokenLoads}, expireAt)
+	if err == errLimitReached {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// sendPage renders the download page of item. Its preview and download
+// links carry token, when the view was counted, so that they do not count
+// or rotate the upload again.
+func sendPage(ctx context.Context, resp *events.APIGatewayProxyResponse, req events.APIGatewayProxyRequest, item *transferItem, token string) error {
+	link := requestDomain(req) + "/" + item.S3Key + "/" + downloadName(item)
+	suffix := ""
+	if token != "" {
+		suffix = "&page=" + token
+	}
+	page := struct {
+		Filename    string
+		Kind        string
+		InlineURL   string
+		DownloadURL string
+		Text        string
+	}{
+		Filename:    downloadName(item),
+		Kind:        previewKind(item),
+		InlineURL:   link + "?inline=1" + suffix,
+		DownloadURL: link + "?download=1" + suffix,
+	}
+
+	if page.Kind == previewText {
+		text, err := previewTextOf(ctx, item)
+		if err != nil {
+			return err
+		}
+		page.Text = text
+	}
+
+	var buf bytes.Buffer
+	if err := downloadPageTemplate.Execute(&buf, page); err != nil {
+		return err
+	}
+
+	resp.StatusCode = http.StatusOK
+	resp.Headers = map[string]string{
+		"Content-Type": "text/html; charset=utf-8",
+	}
+	resp.Body = buf.String()
+	return nil
+}
+
+// previewTextOf reads the start of a text upload, cut back to whole runes.
+func previewTextOf(ctx context.Context, item *transferItem) (string, error) {
+	rc, err := objects.Get(ctx, item.ObjectPath(), item.VersionID)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	b, err := ioutil.ReadAll(io.LimitReader(rc, previewTextMax))
+	if err != nil {
+		return "", err
+	}
+	for len(b) > 0 && !utf8.Valid(b) {
+		b = b[:len(b)-1]
+	}
+	return string(b), nil
+}
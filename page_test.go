@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// usePreviewKinds sets PREVIEW_KINDS for the length of the test.
+func usePreviewKinds(t *testing.T, kinds map[string]string) {
+	old := previewKinds
+	previewKinds = kinds
+	t.Cleanup(func() { previewKinds = old })
+}
+
+// useDownloadPage turns DOWNLOAD_PAGE on with the default previews.
+func useDownloadPage(t *testing.T) {
+	useTestStores(t)
+	setBool(t, &downloadPage, true)
+	usePreviewKinds(t, defaultPreviewKinds)
+}
+
+var browser = map[string]string{"Accept": "text/html,application/xhtml+xml"}
+
+func TestParsePreviewKinds(t *testing.T) {
+	kinds, err := parsePreviewKinds([]string{"image/*=img", " Application/PDF = iframe", "text/csv=pre"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kinds) != 3 || kinds["image/*"] != previewImage || kinds["application/pdf"] != previewFrame || kinds["text/csv"] != previewText {
+		t.Errorf("parsed %v", kinds)
+	}
+
+	for _, entry := range []string{"image/*", "image/*=video", "=img=x"} {
+		if _, err := parsePreviewKinds([]string{entry}); err == nil {
+			t.Errorf("%q accepted", entry)
+		}
+	}
+}
+
+func TestPreviewKind(t *testing.T) {
+	usePreviewKinds(t, defaultPreviewKinds)
+
+	tests := []struct {
+		item transferItem
+		want string
+	}{
+		{transferItem{Filename: "a.png", ContentType: "image/png", SniffedType: "image/png"}, previewImage},
+		{transferItem{Filename: "a.pdf", ContentType: "application/pdf", SniffedType: "application/pdf"}, previewFrame},
+		{transferItem{Filename: "a.txt", ContentType: "text/plain; charset=utf-8"}, previewText},
+		{transferItem{Filename: "a.csv", ContentType: "text/csv"}, previewText},
+		{transferItem{Filename: "a.zip", ContentType: "application/zip"}, ""},
+		{transferItem{Filename: "a.html", ContentType: "image/png", SniffedType: "image/png"}, ""},
+		{transferItem{Filename: "a.svg", ContentType: "image/svg+xml", SniffedType: "image/svg+xml"}, ""},
+		{transferItem{Filename: "a.png", ContentType: "image/png", SniffedType: "image/png", PasswordHash: "x"}, ""},
+	}
+	for _, tt := range tests {
+		if got := previewKind(&tt.item); got != tt.want {
+			t.Errorf("previewKind(%s as %s) = %q, want %q", tt.item.Filename, tt.item.ContentType, got, tt.want)
+		}
+	}
+}
+
+func TestDownloadPagePreviews(t *testing.T) {
+	useDownloadPage(t)
+	setInt(t, &maxDownloads, 10)
+
+	tests := []struct {
+		name, body string
+		want       []string
+		not        []string
+	}{
+		{"cat.png", pngHead, []string{`<img src="`, `alt="cat.png"`}, []string{"<iframe", "<pre>"}},
+		{"doc.pdf", "%PDF-1.4 doc", []string{`<iframe src="`, "sandbox"}, []string{"<img", "<pre>"}},
+		{"notes.txt", "<script>alert(1)</script>", []string{"<pre>&lt;script&gt;alert(1)&lt;/script&gt;</pre>"}, []string{"<script>", "<img", "<iframe"}},
+		{"data.zip", "PK\x03\x04rest", nil, []string{"<img", "<iframe", "<pre>"}},
+		{"cat.html", pngHead, nil, []string{"<img", "<iframe", "<pre>"}},
+	}
+	for _, tt := range tests {
+		link, _ := testUpload(t, tt.name, tt.body, nil)
+		resp := serve(t, testRequest("GET", link, browser, ""))
+		if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Headers["Content-Type"], "text/html") {
+			t.Fatalf("%s: page %d, %q", tt.name, resp.StatusCode, resp.Headers["Content-Type"])
+		}
+		want := append(tt.want, "<title>"+tt.name+"</title>", `download>Download `+tt.name+`</a>`)
+		for _, s := range want {
+			if !strings.Contains(resp.Body, s) {
+				t.Errorf("%s: page lacks %s:\n%s", tt.name, s, resp.Body)
+			}
+		}
+		for _, s := range tt.not {
+			if strings.Contains(resp.Body, s) {
+				t.Errorf("%s: page holds %s:\n%s", tt.name, s, resp.Body)
+			}
+		}
+	}
+}
+
+func TestDownloadPageEscapesFilename(t *testing.T) {
+	useDownloadPage(t)
+
+	link, _ := testUpload(t, `"><b>x.zip`, "PK\x03\x04rest", nil)
+	resp := serve(t, testRequest("GET", link, browser, ""))
+	if strings.Contains(resp.Body, "<b>") {
+		t.Errorf("filename not escaped:\n%s", resp.Body)
+	}
+}
+
+var pageToken = regexp.MustCompile(`page=([0-9a-f]+)`)
+
+func TestPageCountsOnce(t *testing.T) {
+	useDownloadPage(t)
+	setInt(t, &maxDownloads, 2)
+	setBool(t, &rotateOnAccess, true)
+
+	link, _ := testUpload(t, "cat.png", pngHead, nil)
+	key := keyOf(link)
+
+	page := serve(t, testRequest("GET", link, browser, ""))
+	m := pageToken.FindStringSubmatch(page.Body)
+	if m == nil {
+		t.Fatalf("no page token in:\n%s", page.Body)
+	}
+	token := m[1]
+
+	tests := []struct {
+		query  string
+		status int
+	}{
+		{"?inline=1&page=" + token, http.StatusFound},
+		{"?download=1&page=" + token, http.StatusFound},
+		{"?download=1&page=" + token, http.StatusForbidden},
+		{"?inline=1&page=0000", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		resp := serve(t, testRequest("GET", link+tt.query, nil, ""))
+		if resp.StatusCode != tt.status {
+			t.Errorf("GET %s: %d, want %d", tt.query, resp.StatusCode, tt.status)
+		}
+		if resp.Headers["Link"] != "" {
+			t.Errorf("GET %s rotated the upload", tt.query)
+		}
+	}
+
+	item, err := meta.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Times != 1 || item.RotatedTo != "" {
+		t.Errorf("page view counted %d downloads, rotated to %q", item.Times, item.RotatedTo)
+	}
+
+	// the second view takes the last download, a third finds none left
+	if resp := serve(t, testRequest("GET", link, browser, "")); resp.StatusCode != http.StatusOK {
+		t.Errorf("second view: %d", resp.StatusCode)
+	}
+	if resp := serve(t, testRequest("GET", link, browser, "")); resp.StatusCode != http.StatusGone {
+		t.Errorf("third view: %d, want 410", resp.StatusCode)
+	}
+}
+
+func TestPageWithoutPreviewCountsNothing(t *testing.T) {
+	useDownloadPage(t)
+	setInt(t, &maxDownloads, 1)
+
+	link, _ := testUpload(t, "data.zip", "PK\x03\x04rest", nil)
+	for i := 0; i < 3; i++ {
+		resp := serve(t, testRequest("GET", link, browser, ""))
+		if resp.StatusCode != http.StatusOK || pageToken.MatchString(resp.Body) {
+			t.Fatalf("view %d: %d\n%s", i, resp.StatusCode, resp.Body)
+		}
+	}
+	if resp := serve(t, testRequest("GET", link+"?download=1", nil, "")); resp.StatusCode != http.StatusFound {
+		t.Errorf("download after the views: %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// passwordIterations is the PBKDF2 work factor for new password hashes.
+// Stored hashes carry their own, so it can be raised later.
+const passwordIterations = 50000
+
+// hashPassword returns a salted PBKDF2-SHA256 hash of password in the form
+// "pbkdf2-sha256$<iterations>$<hex salt>$<hex hash>".
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	sum := pbkdf2SHA256([]byte(password), salt, passwordIterations)
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", passwordIterations,
+		hex.EncodeToString(salt), hex.EncodeToString(sum)), nil
+}
+
+// checkPassword reports whether password matches hash. A malformed hash
+// matches nothing.
+func checkPassword(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iter, err := strconv.Atoi(parts[1])
+	if err != nil || iter < 1 {
+		return false
+	}
+	salt, err := hex.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+
+	got := pbkdf2SHA256([]byte(password), salt, iter)
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// pbkdf2SHA256 derives a single sha256-sized block as in RFC 8018, which is
+// all a password hash needs.
+func pbkdf2SHA256(password, salt []byte, iter int) []byte {
+	prf := hmac.New(sha256.New, password)
+	prf.Write(salt)
+	prf.Write([]byte{0, 0, 0, 1})
+	u := prf.Sum(nil)
+
+	t := make([]byte, len(u))
+	copy(t, u)
+	for i := 1; i < iter; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range t {
+			t[j] ^= u[j]
+		}
+	}
+	return t
+}
+
+// passwordStatus checks a presented password against one of the password
+// hashes of an upload: 200 when there is no such password or it matches,
+// 401 otherwise.
+func passwordStatus(hash, password string) int {
+	if hash == "" || password != "" && checkPassword(hash, password) {
+		return http.StatusOK
+	}
+	return http.StatusUnauthorized
+}
+
+// downloadPasswordStatus checks the X-Password of a download of item.
+func downloadPasswordStatus(req events.APIGatewayProxyRequest, item *transferItem) int {
+	return passwordStatus(item.PasswordHash, header(req, "X-Password"))
+}
+
+// viewPasswordStatus checks the X-View-Password of a look at the metadata
+// or preview of item. It is independent of the download password.
+func viewPasswordStatus(req events.APIGatewayProxyRequest, item *transferItem) int {
+	return passwordStatus(item.ViewPasswordHash, header(req, "X-View-Password"))
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPBKDF2SHA256(t *testing.T) {
+	// the first block of the vectors in RFC 7914, section 11
+	tests := []struct {
+		password, salt string
+		iter           int
+		want           string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56"},
+	}
+	for _, tt := range tests {
+		if got := hex.EncodeToString(pbkdf2SHA256([]byte(tt.password), []byte(tt.salt), tt.iter)); got != tt.want {
+			t.Errorf("pbkdf2(%q, %q, %d) = %s, want %s", tt.password, tt.salt, tt.iter, got, tt.want)
+		}
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	hash, err := hashPassword("open sesame")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := hashPassword("open sesame")
+	if hash == other {
+		t.Error("two hashes of one password share a salt")
+	}
+
+	tests := []struct {
+		hash, password string
+		want           bool
+	}{
+		{hash, "open sesame", true},
+		{hash, "open sesame ", false},
+		{hash, "", false},
+		{"", "", false},
+		{"plain", "plain", false},
+		{"md5$1$00$00", "x", false},
+		{"pbkdf2-sha256$0$00$00", "x", false},
+		{"pbkdf2-sha256$1$zz$00", "x", false},
+	}
+	for _, tt := range tests {
+		if got := checkPassword(tt.hash, tt.password); got != tt.want {
+			t.Errorf("checkPassword(%q, %q) = %v", tt.hash, tt.password, got)
+		}
+	}
+}
+
+func TestHeadChecksPassword(t *testing.T) {
+	useTestStores(t)
+
+	link, _ := testUpload(t, "a.txt", "hello", map[string]string{"X-Password": "pw"})
+	tests := []struct {
+		password string
+		want     int
+	}{
+		{"pw", http.StatusOK},
+		{"wrong", http.StatusUnauthorized},
+		{"", http.StatusUnauthorized},
+		{"PW", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		resp := serve(t, testRequest("HEAD", link, map[string]string{"X-Password": tt.password}, ""))
+		if resp.StatusCode != tt.want {
+			t.Errorf("HEAD with %q: %d, want %d", tt.password, resp.StatusCode, tt.want)
+		}
+	}
+
+	item, err := meta.Get(context.Background(), keyOf(link))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Times != 0 {
+		t.Errorf("HEAD counted %d downloads", item.Times)
+	}
+	if item.PasswordHash == "" || item.PasswordHash == "pw" {
+		t.Errorf("password stored as %q", item.PasswordHash)
+	}
+}
+
+func TestDownloadPassword(t *testing.T) {
+	useTestStores(t)
+	setInt(t, &maxDownloads, 5)
+
+	link, _ := testUpload(t, "a.txt", "hello", map[string]string{"X-Password": "pw"})
+	tests := []struct {
+		password string
+		want     int
+	}{
+		{"", http.StatusUnauthorized},
+		{"wrong", http.StatusUnauthorized},
+		{"pw", http.StatusFound},
+	}
+	for _, tt := range tests {
+		resp := serve(t, testRequest("GET", link, map[string]string{"X-Password": tt.password}, ""))
+		if resp.StatusCode != tt.want {
+			t.Errorf("GET with %q: %d, want %d", tt.password, resp.StatusCode, tt.want)
+		}
+	}
+
+	item, _ := meta.Get(context.Background(), keyOf(link))
+	if item.Times != 1 {
+		t.Errorf("counted %d downloads, want only the one with the password", item.Times)
+	}
+}
+
+func TestViewAndDownloadPasswords(t *testing.T) {
+	view := map[string]string{"X-View-Password": "look"}
+	download := map[string]string{"X-Password": "fetch"}
+	both := map[string]string{"X-View-Password": "look", "X-Password": "fetch"}
+
+	type statuses struct{ info, head, page, get int }
+	tests := []struct {
+		name      string
+		uploaded  map[string]string
+		presented map[string]string
+		want      statuses
+	}{
+		{"both set, none given", both, nil, statuses{401, 401, 401, 401}},
+		{"both set, view given", both, view, statuses{200, 401, 200, 401}},
+		{"both set, download given", both, download, statuses{401, 401, 401, 302}},
+		{"both set, both given", both, both, statuses{200, 200, 200, 302}},
+		{"view set, none given", view, nil, statuses{401, 401, 401, 302}},
+		{"view set, view given", view, view, statuses{200, 200, 200, 302}},
+		{"download set, none given", download, nil, statuses{200, 401, 200, 401}},
+		{"download set, download given", download, download, statuses{200, 200, 200, 302}},
+		{"none set", nil, nil, statuses{200, 200, 200, 302}},
+	}
+	for _, tt := range tests {
+		useDownloadPage(t)
+		setInt(t, &maxDownloads, 5)
+
+		link, _ := testUpload(t, "a.txt", "hello", tt.uploaded)
+		page := map[string]string{"Accept": browser["Accept"]}
+		for k, v := range tt.presented {
+			page[k] = v
+		}
+
+		got := statuses{
+			info: serve(t, testRequest("GET", "/info"+link, tt.presented, "")).StatusCode,
+			head: serve(t, testRequest("HEAD", link, tt.presented, "")).StatusCode,
+			page: serve(t, testRequest("GET", link, page, "")).StatusCode,
+			get:  serve(t, testRequest("GET", link, tt.presented, "")).StatusCode,
+		}
+		if got != tt.want {
+			t.Errorf("%s: info, head, page, download %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestViewPasswordIsHashed(t *testing.T) {
+	useTestStores(t)
+
+	link, _ := testUpload(t, "a.txt", "hello", map[string]string{"X-View-Password": "look", "X-Password": "fetch"})
+	item, err := meta.Get(context.Background(), keyOf(link))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.ViewPasswordHash == "" || item.ViewPasswordHash == item.PasswordHash || !checkPassword(item.ViewPasswordHash, "look") {
+		t.Errorf("view password stored as %q beside %q", item.ViewPasswordHash, item.PasswordHash)
+	}
+}
+
+func TestPasswordProtectedPageHasNoPreview(t *testing.T) {
+	useDownloadPage(t)
+
+	link, _ := testUpload(t, "a.txt", "top secret", map[string]string{"X-Password": "fetch"})
+	resp := serve(t, testRequest("GET", link, browser, ""))
+	if resp.StatusCode != http.StatusOK || strings.Contains(resp.Body, "top secret") || strings.Contains(resp.Body, "<pre>") {
+		t.Errorf("page of a password protected upload: %d\n%s", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestPublicUploadRefusesPasswords(t *testing.T) {
+	tests := []struct {
+		mode    string
+		headers map[string]string
+		want    int
+	}{
+		{publicOptional, map[string]string{"X-Public": "true", "X-Password": "s3cret"}, http.StatusBadRequest},
+		{publicOptional, map[string]string{"X-Public": "true", "X-View-Password": "s3cret"}, http.StatusBadRequest},
+		{publicOptional, map[string]string{"X-Public": "true"}, http.StatusOK},
+		{publicOptional, map[string]string{"X-Password": "s3cret"}, http.StatusOK},
+		{publicAlways, map[string]string{"X-Password": "s3cret"}, http.StatusBadRequest},
+		{publicNever, map[string]string{"X-Public": "true", "X-Password": "s3cret"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		st, _ := useTestStores(t)
+		setString(t, &publicRead, tt.mode)
+
+		resp := serve(t, testRequest("PUT", "/a.txt", tt.headers, "hello"))
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s, %v: %d, want %d", tt.mode, tt.headers, resp.StatusCode, tt.want)
+		}
+		if stored := len(st.items) > 0; stored != (tt.want == http.StatusOK) {
+			t.Errorf("%s, %v: stored %d records", tt.mode, tt.headers, len(st.items))
+		}
+	}
+}
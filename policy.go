@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+var policyClient = &http.Client{}
+
+// policyRequest describes an upload to the policy service. The uploader is
+// the caller id, so a raw API key never leaves the function.
+type policyRequest struct {
+	Filename    string `json:"filename"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+	IP          string `json:"ip"`
+	Uploader    string `json:"uploader"`
+}
+
+type policyDecision struct {
+	Allow  bool   `json:"allow"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// checkPolicy asks the service at POLICY_URL whether r may be uploaded,
+// giving it POLICY_TIMEOUT to answer. When the service cannot be reached
+// the upload is allowed under POLICY_FAIL_OPEN and refused otherwise.
+func checkPolicy(ctx context.Context, r *transferItem) policyDecision {
+	if policyURL == "" {
+		return policyDecision{Allow: true}
+	}
+
+	d, err := askPolicy(ctx, policyRequest{
+		Filename:    r.Filename,
+		Size:        r.Size,
+		ContentType: r.ContentType,
+		IP:          r.IP,
+		Uploader:    r.Uploader,
+	})
+	if err != nil {
+		log.Printf("policy: %v", err)
+		return policyDecision{Allow: policyFailOpen, Reason: "policy check unavailable"}
+	}
+	return d
+}
+
+func askPolicy(ctx context.Context, pr policyRequest) (d policyDecision, err error) {
+	b, err := json.Marshal(pr)
+	if err != nil {
+		return d, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, policyTimeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodPost, policyURL, bytes.NewReader(b))
+	if err != nil {
+		return d, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := policyClient.Do(req)
+	if err != nil {
+		return d, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		return d, fmt.Errorf("policy answered %s", res.Status)
+	}
+	err = json.NewDecoder(res.Body).Decode(&d)
+	return d, err
+}
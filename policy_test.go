@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useTestPolicy points POLICY_URL at handler and returns the requests it
+// was asked about.
+func useTestPolicy(t *testing.T, handler http.HandlerFunc) *[]policyRequest {
+	t.Helper()
+
+	var asked []policyRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var pr policyRequest
+		if err := json.NewDecoder(r.Body).Decode(&pr); err != nil {
+			t.Errorf("policy request: %v", err)
+		}
+		asked = append(asked, pr)
+		handler(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	setString(t, &policyURL, srv.URL)
+	setDuration(t, &policyTimeout, 50*time.Millisecond)
+	return &asked
+}
+
+// answer replies to a policy request with the decision d.
+func answer(d policyDecision) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(d)
+	}
+}
+
+func TestPolicyDecision(t *testing.T) {
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		answer(policyDecision{Allow: true})(w, r)
+	}
+	broken := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		failOpen bool
+		status   int
+		body     string
+	}{
+		{"allow", answer(policyDecision{Allow: true}), false, http.StatusOK, ""},
+		{"deny", answer(policyDecision{Reason: "no executables"}), false, http.StatusForbidden, "upload refused by policy: no executables\n"},
+		{"deny without reason", answer(policyDecision{}), true, http.StatusForbidden, "upload refused by policy\n"},
+		{"timeout, closed", slow, false, http.StatusForbidden, "upload refused by policy: policy check unavailable\n"},
+		{"timeout, open", slow, true, http.StatusOK, ""},
+		{"error, closed", broken, false, http.StatusForbidden, "upload refused by policy: policy check unavailable\n"},
+		{"error, open", broken, true, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		useTestStores(t)
+		useTestPolicy(t, tt.handler)
+		setBool(t, &policyFailOpen, tt.failOpen)
+
+		resp := serve(t, testRequest("PUT", "/a.txt", nil, "hello"))
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: %d, want %d", tt.name, resp.StatusCode, tt.status)
+		}
+		if tt.body != "" && resp.Body != tt.body {
+			t.Errorf("%s: body %q, want %q", tt.name, resp.Body, tt.body)
+		}
+	}
+}
+
+func TestPolicyRequest(t *testing.T) {
+	useTestStores(t)
+	asked := useTestPolicy(t, answer(policyDecision{Allow: true}))
+
+	old := apiKeys
+	apiKeys = map[string]bool{"secret-key": true}
+	defer func() { apiKeys = old }()
+
+	testUpload(t, "notes.txt", "hello", map[string]string{"X-API-Key": "secret-key"})
+	if len(*asked) != 1 {
+		t.Fatalf("asked %d times", len(*asked))
+	}
+	pr := (*asked)[0]
+	if pr.Filename != "notes.txt" || pr.Size != 5 || !strings.HasPrefix(pr.ContentType, "text/plain") || pr.IP != "192.0.2.1" {
+		t.Errorf("asked about %+v", pr)
+	}
+	if !strings.HasPrefix(pr.Uploader, "key:") || strings.Contains(pr.Uploader, "secret-key") {
+		t.Errorf("uploader sent as %q", pr.Uploader)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// maxPresignTTL is the longest lifetime S3 accepts for a SigV4 presigned url.
+const maxPresignTTL = 7 * 24 * time.Hour
+
+// clampPresignTTL keeps d within what S3 will accept, logging any change.
+func clampPresignTTL(d time.Duration) time.Duration {
+	switch {
+	case d > maxPresignTTL:
+		log.Printf("presign ttl %s exceeds the S3 maximum, clamping to %s", d, maxPresignTTL)
+		return maxPresignTTL
+	case d <= 0:
+		log.Printf("presign ttl %s is not positive, using %s", d, defaultPresignTTL)
+		return defaultPresignTTL
+	}
+	return d
+}
+
+// presignTTLFor returns the lifetime of a download url for item. It never
+// outlives the upload itself.
+func presignTTLFor(item *transferItem) time.Duration {
+	d := presignTTL
+	if left := time.Until(time.Unix(item.ExpireAt, 0)); item.ExpireAt > 0 && left < d {
+		d = left
+	}
+	if d < time.Second {
+		d = time.Second
+	}
+	return clampPresignTTL(d)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestClampPresignTTL(t *testing.T) {
+	tests := []struct {
+		in, want time.Duration
+	}{
+		{time.Minute, time.Minute},
+		{maxPresignTTL, maxPresignTTL},
+		{maxPresignTTL + time.Second, maxPresignTTL},
+		{30 * 24 * time.Hour, maxPresignTTL},
+		{0, defaultPresignTTL},
+		{-time.Minute, defaultPresignTTL},
+	}
+	for _, tt := range tests {
+		if got := clampPresignTTL(tt.in); got != tt.want {
+			t.Errorf("clampPresignTTL(%v) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPresignTTLFor(t *testing.T) {
+	setDuration(t, &presignTTL, 15*time.Minute)
+	now := time.Now()
+
+	tests := []struct {
+		expireAt int64
+		lo, hi   time.Duration
+	}{
+		{0, 15 * time.Minute, 15 * time.Minute},
+		{now.Add(time.Hour).Unix(), 15 * time.Minute, 15 * time.Minute},
+		{now.Add(5 * time.Minute).Unix(), 4 * time.Minute, 5 * time.Minute},
+		{now.Add(-time.Minute).Unix(), time.Second, time.Second},
+	}
+	for _, tt := range tests {
+		if got := presignTTLFor(&transferItem{ExpireAt: tt.expireAt}); got < tt.lo || got > tt.hi {
+			t.Errorf("expiring at %d: %v, want within [%v, %v]", tt.expireAt, got, tt.lo, tt.hi)
+		}
+	}
+}
+
+func TestPresignedURLValid(t *testing.T) {
+	st, _ := useTestS3(t, ok)
+
+	for _, ttl := range []time.Duration{time.Minute, clampPresignTTL(30 * 24 * time.Hour)} {
+		u, err := st.URL(context.Background(), "k", ttl, urlOptions{})
+		if err != nil {
+			t.Fatalf("ttl %v: %v", ttl, err)
+		}
+		q, err := url.Parse(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := q.Query().Get("X-Amz-Expires"); got != strconv.Itoa(int(ttl/time.Second)) {
+			t.Errorf("ttl %v: X-Amz-Expires %s", ttl, got)
+		}
+	}
+}
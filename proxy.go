@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+const (
+	downloadRedirect = "redirect"
+	downloadProxy    = "proxy"
+)
+
+// proxyMaxSize is the largest object returned in the response body. API
+// Gateway caps responses at 6MB and binary bodies grow by a third as base64.
+const proxyMaxSize = 4 << 20
+
+var errStalled = errors.New("download stalled")
+
+// readObject reads the object at key, aborting with errStalled when no
+// data arrives for proxyReadTimeout. It reports false, with no data, when
+// the object is larger than proxyMaxSize. Partial reads are discarded.
+func readObject(ctx context.Context, item *transferItem) ([]byte, bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	rc, err := objects.Get(ctx, item.ObjectPath(), item.VersionID)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rc.Close()
+
+	type result struct {
+		data []byte
+		fits bool
+		err  error
+	}
+	var (
+		progress = make(chan struct{}, 1)
+		done     = make(chan result, 1)
+	)
+
+	go func() {
+		var (
+			data []byte
+			buf  = make([]byte, 32<<10)
+		)
+		for {
+			n, err := rc.Read(buf)
+			data = append(data, buf[:n]...)
+			if n > 0 {
+				select {
+				case progress <- struct{}{}:
+				default:
+				}
+			}
+			if len(data) > proxyMaxSize {
+				done <- result{nil, false, nil}
+				return
+			}
+			if err == io.EOF {
+				done <- result{data, true, nil}
+				return
+			}
+			if err != nil {
+				done <- result{nil, false, err}
+				return
+			}
+		}
+	}()
+
+	timer := time.NewTimer(proxyReadTimeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-progress:
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(proxyReadTimeout)
+		case r := <-done:
+			return r.data, r.fits, r.err
+		case <-timer.C:
+			cancel()
+			rc.Close()
+			<-done
+			return nil, false, errStalled
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowStorage serves objects a chunk at a time, pausing before each one, and
+// stalls for good after stallAfter chunks unless that is negative.
+type slowStorage struct {
+	*fsStorage
+	chunks     int
+	pause      time.Duration
+	stallAfter int
+}
+
+func (st *slowStorage) Get(ctx context.Context, key, version string) (io.ReadCloser, error) {
+	return &slowReader{st: st, closed: make(chan struct{})}, nil
+}
+
+type slowReader struct {
+	st     *slowStorage
+	sent   int
+	closed chan struct{}
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if r.sent == r.st.chunks {
+		return 0, io.EOF
+	}
+
+	pause := r.st.pause
+	if r.st.stallAfter >= 0 && r.sent >= r.st.stallAfter {
+		pause = time.Hour
+	}
+	select {
+	case <-time.After(pause):
+	case <-r.closed:
+		return 0, errors.New("read on closed body")
+	}
+
+	r.sent++
+	return copy(p, "x"), nil
+}
+
+func (r *slowReader) Close() error {
+	select {
+	case <-r.closed:
+	default:
+		close(r.closed)
+	}
+	return nil
+}
+
+func TestReadObjectStall(t *testing.T) {
+	setDuration(t, &proxyReadTimeout, 50*time.Millisecond)
+
+	tests := []struct {
+		name       string
+		chunks     int
+		pause      time.Duration
+		stallAfter int
+		err        error
+	}{
+		{"fast", 3, 0, -1, nil},
+		{"slow but steady", 10, 20 * time.Millisecond, -1, nil},
+		{"stalled at once", 3, 0, 0, errStalled},
+		{"stalled midway", 10, 5 * time.Millisecond, 4, errStalled},
+	}
+	for _, tt := range tests {
+		old := objects
+		objects = &slowStorage{fsStorage: &fsStorage{}, chunks: tt.chunks, pause: tt.pause, stallAfter: tt.stallAfter}
+
+		data, fits, err := readObject(context.Background(), &transferItem{S3Key: "abcde"})
+		objects = old
+		if err != tt.err {
+			t.Errorf("%s: %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		if err == nil && (!fits || string(data) != strings.Repeat("x", tt.chunks)) {
+			t.Errorf("%s: read %q, fits %v", tt.name, data, fits)
+		}
+		if err != nil && data != nil {
+			t.Errorf("%s: kept %d partial bytes", tt.name, len(data))
+		}
+	}
+}
+
+func TestStalledDownload(t *testing.T) {
+	_, fs := useTestStores(t)
+	setString(t, &downloadMode, downloadProxy)
+	setDuration(t, &proxyReadTimeout, 20*time.Millisecond)
+
+	link, _ := testUpload(t, "a.txt", "hello", nil)
+	objects = &slowStorage{fsStorage: fs, chunks: 5, stallAfter: 1}
+
+	resp := serve(t, testRequest("GET", link, nil, ""))
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("stalled download: %d, want 504", resp.StatusCode)
+	}
+	item, err := meta.Get(context.Background(), keyOf(link))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Times != 0 {
+		t.Errorf("stalled download counted")
+	}
+}
+
+func TestProxyTooLarge(t *testing.T) {
+	useTestStores(t)
+	setString(t, &downloadMode, downloadProxy)
+
+	link, _ := testUpload(t, "big.bin", strings.Repeat("x", proxyMaxSize+1), nil)
+	resp := serve(t, testRequest("GET", link, nil, ""))
+	if resp.StatusCode != http.StatusFound {
+		t.Errorf("oversized download: %d, want a redirect", resp.StatusCode)
+	}
+}
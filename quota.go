@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+var (
+	errBadAPIKey     = errors.New("invalid api key")
+	errQuotaExceeded = errors.New("quota exceeded")
+)
+
+// caller identifies who is uploading: an API key when one is presented and
+// the source IP otherwise. Keys are hashed so they never reach the table.
+func caller(req events.APIGatewayProxyRequest) (id string, authenticated bool, err error) {
+	key := header(req, "X-API-Key")
+	if key == "" {
+		return "ip:" + req.RequestContext.Identity.SourceIP, false, nil
+	}
+	if !apiKeys[key] {
+		return "", false, errBadAPIKey
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	return "key:" + hex.EncodeToString(sum[:8]), true, nil
+}
+
+// quotaWindow returns the start and end of the upload window containing t.
+func quotaWindow(t time.Time) (time.Time, time.Time) {
+	start := t.Truncate(uploadWindow)
+	return start, start.Add(uploadWindow)
+}
+
+// quotaKey names the counter item for id in the window starting at start.
+// It shares the transfer table, but never collides with a hex key.
+func quotaKey(id string, start time.Time) string {
+	return fmt.Sprintf("quota#%s#%d", id, start.Unix())
+}
+
+// chargeQuota counts an upload of size bytes made at now against id,
+// failing with errQuotaExceeded when it would go over either limit.
+func chargeQuota(ctx context.Context, id string, size int64, now time.Time) error {
+	if uploadLimit == 0 && uploadBytesLimit == 0 {
+		return nil
+	}
+
+	start, end := quotaWindow(now)
+
+	limits := map[string]int64{}
+	if uploadLimit > 0 {
+		limits["uploads"] = int64(uploadLimit)
+	}
+	if uploadBytesLimit > 0 {
+		limits["bytes"] = uploadBytesLimit
+	}
+
+	err := meta.Incr(ctx, quotaKey(id, start), map[string]int64{
+		"uploads": 1,
+		"bytes":   size,
+	}, limits, end.Unix())
+	if err == errLimitReached {
+		return errQuotaExceeded
+	}
+	return err
+}
+
+// refundQuota gives back what chargeQuota charged for an upload made at
+// now that failed after all. It runs even when the request was cancelled.
+func refundQuota(id string, size int64, now time.Time) {
+	if uploadLimit == 0 && uploadBytesLimit == 0 {
+		return
+	}
+
+	start, end := quotaWindow(now)
+	err := meta.Incr(context.Background(), quotaKey(id, start), map[string]int64{
+		"uploads": -1,
+		"bytes":   -size,
+	}, nil, end.Unix())
+	if err != nil {
+		log.Printf("refund quota of %s: %v", id, err)
+	}
+}
+
+type quotaUsage struct {
+	WindowStart      int64  `json:"window_start"`
+	WindowEnd        int64  `json:"window_end"`
+	Uploads          int64  `json:"uploads"`
+	UploadsLimit     int    `json:"uploads_limit,omitempty"`
+	UploadsRemaining *int64 `json:"uploads_remaining,omitempty"`
+	Bytes            int64  `json:"bytes"`
+	BytesLimit       int64  `json:"bytes_limit,omitempty"`
+	BytesRemaining   *int64 `json:"bytes_remaining,omitempty"`
+	ActiveLinks      *int64 `json:"active_links,omitempty"`
+}
+
+func remaining(limit, used int64) *int64 {
+	n := limit - used
+	if n < 0 {
+		n = 0
+	}
+	return &n
+}
+
+// activeLinks counts the uploads of id that can still be downloaded. It
+// needs the uploader index, so it returns nil when UPLOADER_INDEX is not
+// configured.
+func activeLinks(ctx context.Context, id string) (*int64, error) {
+	items, err := meta.ByUploader(ctx, id)
+	if err == errNoIndex {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// forwarders left by rotation answer 410, so only the live link counts
+	var n int64
+	now := time.Now()
+	for _, item := range items {
+		if itemStatus(item, now) == http.StatusOK {
+			n++
+		}
+	}
+	return &n, nil
+}
+
+// quota reports the caller's usage in the current upload window.
+func quota(ctx context.Context, req events.APIGatewayProxyRequest) (resp events.APIGatewayProxyResponse, err error) {
+	id, _, err := caller(req)
+	if err != nil {
+		resp.StatusCode = http.StatusUnauthorized
+		err = nil
+		return
+	}
+
+	start, end := quotaWindow(time.Now())
+	u := quotaUsage{
+		WindowStart:  start.Unix(),
+		WindowEnd:    end.Unix(),
+		UploadsLimit: uploadLimit,
+		BytesLimit:   uploadBytesLimit,
+	}
+
+	counters, err := meta.Counters(ctx, quotaKey(id, start))
+	if err != nil {
+		resp.StatusCode = http.StatusInternalServerError
+		return
+	}
+	u.Uploads, u.Bytes = counters["uploads"], counters["bytes"]
+
+	if uploadLimit > 0 {
+		u.UploadsRemaining = remaining(int64(uploadLimit), u.Uploads)
+	}
+	if uploadBytesLimit > 0 {
+		u.BytesRemaining = remaining(uploadBytesLimit, u.Bytes)
+	}
+
+	if u.ActiveLinks, err = activeLinks(ctx, id); err != nil {
+		resp.StatusCode = http.StatusInternalServerError
+		return
+	}
+
+	b, err := json.Marshal(u)
+	if err != nil {
+		resp.StatusCode = http.StatusInternalServerError
+		return
+	}
+
+	resp.StatusCode = http.StatusOK
+	resp.Headers = map[string]string{
+		"Content-Type": "application/json",
+	}
+	resp.Body = string(b)
+	return
+}
+
+// dailyDownloadKey names the counter of downloads from ip on the UTC day
+// starting at day.
+func dailyDownloadKey(ip string, day time.Time) string {
+	return fmt.Sprintf("downloads#%s#%s", ip, day.Format("2006-01-02"))
+}
+
+// dailyCharge is a download counted against DAILY_DOWNLOAD_LIMIT, kept so
+// that a download failing after all can be given back.
+type dailyCharge struct {
+	key string
+	end time.Time
+}
+
+// dailyDownloadEnd is when the UTC day containing now ends.
+func dailyDownloadEnd(now time.Time) time.Time {
+	return now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+}
+
+// chargeDailyDownload counts a download from ip against DAILY_DOWNLOAD_LIMIT,
+// across all links, failing with errQuotaExceeded once ip reached it today.
+// It returns nil when there is no limit.
+func chargeDailyDownload(ctx context.Context, ip string) (*dailyCharge, error) {
+	if dailyDownloadLimit == 0 {
+		return nil, nil
+	}
+
+	end := dailyDownloadEnd(time.Now())
+	charge := &dailyCharge{
+		key: dailyDownloadKey(ip, end.Add(-24*time.Hour)),
+		end: end,
+	}
+	err := meta.Incr(ctx, charge.key, map[string]int64{
+		"downloads": 1,
+	}, map[string]int64{
+		"downloads": int64(dailyDownloadLimit),
+	}, end.Unix())
+	if err == errLimitReached {
+		return nil, errQuotaExceeded
+	}
+	if err != nil {
+		return nil, err
+	}
+	return charge, nil
+}
+
+// refund gives the download back, even when the request was cancelled.
+func (charge *dailyCharge) refund() {
+	if charge == nil {
+		return
+	}
+	err := meta.Incr(context.Background(), charge.key, map[string]int64{"downloads": -1}, nil, charge.end.Unix())
+	if err != nil {
+		log.Printf("refund %s: %v", charge.key, err)
+	}
+}
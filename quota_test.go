@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCaller(t *testing.T) {
+	old := apiKeys
+	apiKeys = map[string]bool{"k1": true}
+	defer func() { apiKeys = old }()
+
+	tests := []struct {
+		key    string
+		prefix string
+		auth   bool
+		err    error
+	}{
+		{"", "ip:192.0.2.1", false, nil},
+		{"k1", "key:", true, nil},
+		{"nope", "", false, errBadAPIKey},
+	}
+	for _, tt := range tests {
+		id, auth, err := caller(testRequest("PUT", "/a", map[string]string{"X-API-Key": tt.key}, ""))
+		if err != tt.err || auth != tt.auth || len(id) < len(tt.prefix) || id[:len(tt.prefix)] != tt.prefix {
+			t.Errorf("key %q: %q, %v, %v", tt.key, id, auth, err)
+		}
+	}
+}
+
+func TestQuotaWindow(t *testing.T) {
+	setDuration(t, &uploadWindow, time.Hour)
+	at := time.Date(2024, 6, 1, 10, 42, 0, 0, time.UTC)
+	start, end := quotaWindow(at)
+	if !start.Equal(time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)) || end.Sub(start) != time.Hour {
+		t.Errorf("window of %v: %v to %v", at, start, end)
+	}
+}
+
+func TestQuotaEndpoint(t *testing.T) {
+	useTestStores(t)
+	setInt(t, &uploadLimit, 3)
+	setInt64(t, &uploadBytesLimit, 12)
+
+	testUpload(t, "a.txt", "hello", nil)
+	testUpload(t, "b.txt", "world", nil)
+
+	resp := serve(t, testRequest("PUT", "/c.txt", nil, "toolong"))
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("upload over the byte limit: %d, want 429", resp.StatusCode)
+	}
+
+	var u quotaUsage
+	resp = serve(t, testRequest("GET", "/quota", nil, ""))
+	if err := json.Unmarshal([]byte(resp.Body), &u); err != nil {
+		t.Fatal(err)
+	}
+	if u.Uploads != 2 || u.Bytes != 10 || *u.UploadsRemaining != 1 || *u.BytesRemaining != 2 {
+		t.Errorf("usage %+v", u)
+	}
+	if u.ActiveLinks == nil || *u.ActiveLinks != 2 {
+		t.Errorf("active links %v, want 2", u.ActiveLinks)
+	}
+
+	// another caller has its own counters
+	req := testRequest("GET", "/quota", nil, "")
+	req.RequestContext.Identity.SourceIP = "192.0.2.2"
+	u = quotaUsage{}
+	if err := json.Unmarshal([]byte(serve(t, req).Body), &u); err != nil {
+		t.Fatal(err)
+	}
+	if u.Uploads != 0 || u.Bytes != 0 {
+		t.Errorf("other caller usage %+v", u)
+	}
+
+	bad := serve(t, testRequest("GET", "/quota", map[string]string{"X-API-Key": "nope"}, ""))
+	if bad.StatusCode != http.StatusUnauthorized {
+		t.Errorf("unknown key: %d, want 401", bad.StatusCode)
+	}
+}
+
+// quotaUsed is the number of uploads the test caller was charged for.
+func quotaUsed(t *testing.T) int64 {
+	t.Helper()
+
+	var u quotaUsage
+	if err := json.Unmarshal([]byte(serve(t, testRequest("GET", "/quota", nil, "")).Body), &u); err != nil {
+		t.Fatal(err)
+	}
+	return u.Uploads
+}
+
+func TestRejectedUploadsAreNotCharged(t *testing.T) {
+	useTestStores(t)
+	setInt(t, &uploadLimit, 2)
+	setBool(t, &vanitySlugs, true)
+	setString(t, &maxExpireMode, expireReject)
+	setString(t, &sesSender, "from@transfer.test")
+
+	testUpload(t, "taken.txt", "hello", map[string]string{"X-Slug": "taken"})
+	if n := quotaUsed(t); n != 1 {
+		t.Fatalf("charged %d uploads, want 1", n)
+	}
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{"bad concurrency", map[string]string{"X-Max-Concurrent": "lots"}, http.StatusBadRequest},
+		{"bad expiry", map[string]string{"X-Expire-Hours": "soon"}, http.StatusBadRequest},
+		{"expiry over the ceiling", map[string]string{"X-Expire-Hours": "100000"}, http.StatusBadRequest},
+		{"bad notify address", map[string]string{"X-Notify-On-Download": "not an address"}, http.StatusBadRequest},
+		{"bad slug", map[string]string{"X-Slug": "no/slash"}, http.StatusBadRequest},
+		{"slug taken", map[string]string{"X-Slug": "taken"}, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 2; i++ {
+			if resp := serve(t, testRequest("PUT", "/a.txt", tt.headers, "hello")); resp.StatusCode != tt.want {
+				t.Errorf("%s: %d, want %d", tt.name, resp.StatusCode, tt.want)
+			}
+		}
+	}
+	if n := quotaUsed(t); n != 1 {
+		t.Errorf("rejected uploads charged, %d uploads counted", n)
+	}
+
+	testUpload(t, "b.txt", "hello", nil)
+	if resp := serve(t, testRequest("PUT", "/c.txt", nil, "hello")); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("upload over the limit: %d, want 429", resp.StatusCode)
+	}
+}
+
+func TestFailedUploadIsRefunded(t *testing.T) {
+	useTestStores(t)
+	s3st, _ := useTestS3(t, failingS3(1<<20, http.StatusForbidden))
+	setInt(t, &uploadLimit, 2)
+
+	old := objects
+	objects = s3st
+	for i := 0; i < 3; i++ {
+		resp, _ := handleRequest(context.Background(), testRequest("PUT", "/a.txt", nil, "hello"))
+		if resp.StatusCode != http.StatusInternalServerError {
+			t.Fatalf("upload %d to a failing bucket: %d", i, resp.StatusCode)
+		}
+	}
+	objects = old
+
+	if n := quotaUsed(t); n != 0 {
+		t.Errorf("failed uploads left %d charged", n)
+	}
+	testUpload(t, "a.txt", "hello", nil)
+}
+
+func TestRemaining(t *testing.T) {
+	if n := *remaining(5, 7); n != 0 {
+		t.Errorf("remaining over the limit = %d", n)
+	}
+	if n := *remaining(5, 2); n != 3 {
+		t.Errorf("remaining(5, 2) = %d", n)
+	}
+}
+
+func TestDailyDownloadCap(t *testing.T) {
+	st, _ := useTestStores(t)
+	setInt(t, &dailyDownloadLimit, 2)
+	setInt(t, &maxDownloads, 10)
+
+	var links []string
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		link, _ := testUpload(t, name, "hello", nil)
+		links = append(links, link)
+	}
+
+	tests := []struct {
+		link, ip string
+		want     int
+	}{
+		{links[0], "192.0.2.1", http.StatusFound},
+		{links[1], "192.0.2.1", http.StatusFound},
+		{links[2], "192.0.2.1", http.StatusTooManyRequests},
+		{links[0], "192.0.2.1", http.StatusTooManyRequests},
+		{links[2], "198.51.100.7", http.StatusFound},
+	}
+	for i, tt := range tests {
+		req := testRequest("GET", tt.link, nil, "")
+		req.RequestContext.Identity.SourceIP = tt.ip
+		resp := serve(t, req)
+		if resp.StatusCode != tt.want {
+			t.Errorf("download %d of %s from %s: %d, want %d", i, tt.link, tt.ip, resp.StatusCode, tt.want)
+		}
+		if tt.want == http.StatusTooManyRequests {
+			if s, err := strconv.Atoi(resp.Headers["Retry-After"]); err != nil || s < 1 || s > 24*3600+1 {
+				t.Errorf("download %d: Retry-After %q", i, resp.Headers["Retry-After"])
+			}
+		}
+	}
+
+	// refused downloads leave the per-link counts alone
+	if item, _ := meta.Get(context.Background(), keyOf(links[2])); item.Times != 1 {
+		t.Errorf("%s counted %d downloads, want 1", links[2], item.Times)
+	}
+
+	day := time.Now().UTC().Truncate(24 * time.Hour)
+	counters, _ := st.Counters(context.Background(), dailyDownloadKey("192.0.2.1", day))
+	if counters["downloads"] != 2 || counters["expire_at"] != day.Add(24*time.Hour).Unix() {
+		t.Errorf("daily counter %v", counters)
+	}
+}
+
+func TestDailyDownloadUncapped(t *testing.T) {
+	st, _ := useTestStores(t)
+	setInt(t, &dailyDownloadLimit, 0)
+
+	link, _ := testUpload(t, "a.txt", "hello", nil)
+	serve(t, testRequest("GET", link, nil, ""))
+	for key := range st.items {
+		if strings.HasPrefix(key, "downloads#") {
+			t.Errorf("counted %s without a cap", key)
+		}
+	}
+}
+
+// brokenURLs is a storage that cannot sign download urls.
+type brokenURLs struct {
+	storage
+}
+
+func (st brokenURLs) URL(ctx context.Context, key string, ttl time.Duration, opts urlOptions) (string, error) {
+	return "", errors.New("signing is down")
+}
+
+func TestDailyCapChargesOnlyServedDownloads(t *testing.T) {
+	st, fs := useTestStores(t)
+	setInt(t, &dailyDownloadLimit, 3)
+	setInt(t, &maxConcurrentDownloads, 1)
+	setInt(t, &maxDownloads, 10)
+
+	link, _ := testUpload(t, "a.txt", "hello", nil)
+	other, _ := testUpload(t, "b.txt", "hello", nil)
+
+	tests := []struct {
+		link string
+		want int
+	}{
+		{link, http.StatusFound},
+		// the slot of the first download is still held
+		{link, http.StatusTooManyRequests},
+		{link, http.StatusTooManyRequests},
+		{other, http.StatusFound},
+	}
+	for i, tt := range tests {
+		resp := serve(t, testRequest("GET", tt.link, nil, ""))
+		if resp.StatusCode != tt.want || strings.Contains(resp.Body, "daily") {
+			t.Errorf("download %d: %d %q, want %d", i, resp.StatusCode, resp.Body, tt.want)
+		}
+	}
+
+	objects = brokenURLs{fs}
+	setInt(t, &maxConcurrentDownloads, 0)
+	for i := 0; i < 3; i++ {
+		resp, _ := handleRequest(context.Background(), testRequest("GET", other, nil, ""))
+		if resp.StatusCode != http.StatusInternalServerError {
+			t.Errorf("download %d without signing: %d", i, resp.StatusCode)
+		}
+	}
+
+	day := time.Now().UTC().Truncate(24 * time.Hour)
+	counters, _ := st.Counters(context.Background(), dailyDownloadKey("192.0.2.1", day))
+	if counters["downloads"] != 2 {
+		t.Errorf("daily counter %v, want the 2 downloads served", counters)
+	}
+
+	objects = fs
+	if resp := serve(t, testRequest("GET", other, nil, "")); resp.StatusCode != http.StatusFound {
+		t.Errorf("third download of the day: %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+var errBadReceipt = errors.New("invalid receipt")
+
+// receipt is the signed proof of an upload. It carries the content hash so
+// the uploader can later show what was uploaded without the file itself.
+type receipt struct {
+	Key      string `json:"key"`
+	SHA256   string `json:"sha256"`
+	IssuedAt int64  `json:"issued_at"`
+}
+
+func receiptMAC(payload string) []byte {
+	mac := hmac.New(sha256.New, receiptSecret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// issueReceipt returns a token of the form base64(payload).base64(hmac).
+func issueReceipt(r transferItem) (string, error) {
+	b, err := json.Marshal(receipt{
+		Key:      r.S3Key,
+		SHA256:   r.SHA256,
+		IssuedAt: r.CreatedAt,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(receiptMAC(payload)), nil
+}
+
+func parseReceipt(token string) (rc receipt, err error) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return rc, errBadReceipt
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sig, receiptMAC(parts[0])) {
+		return rc, errBadReceipt
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return rc, errBadReceipt
+	}
+	if err = json.Unmarshal(b, &rc); err != nil {
+		return rc, errBadReceipt
+	}
+	return rc, nil
+}
+
+func verifyReceipt(ctx context.Context, req events.APIGatewayProxyRequest) (resp events.APIGatewayProxyResponse, err error) {
+	if len(receiptSecret) == 0 {
+		resp.StatusCode = http.StatusNotFound
+		return
+	}
+
+	token := req.QueryStringParameters["receipt"]
+	if token == "" {
+		resp.StatusCode = http.StatusBadRequest
+		return
+	}
+
+	rc, err := parseReceipt(token)
+	if err != nil {
+		resp.StatusCode = http.StatusForbidden
+		err = nil
+		return
+	}
+
+	b, err := json.Marshal(rc)
+	if err != nil {
+		resp.StatusCode = http.StatusInternalServerError
+		return
+	}
+
+	resp.StatusCode = http.StatusOK
+	resp.Headers = map[string]string{
+		"Content-Type": "application/json",
+	}
+	resp.Body = string(b)
+	return
+}
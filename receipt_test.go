@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestReceiptRoundTrip(t *testing.T) {
+	receiptSecret = []byte("secret")
+	defer func() { receiptSecret = nil }()
+
+	token, err := issueReceipt(transferItem{S3Key: "abcde", SHA256: "feed", CreatedAt: 1700000000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := parseReceipt(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc != (receipt{Key: "abcde", SHA256: "feed", IssuedAt: 1700000000}) {
+		t.Errorf("parsed %+v", rc)
+	}
+}
+
+func TestReceiptTampered(t *testing.T) {
+	receiptSecret = []byte("secret")
+	defer func() { receiptSecret = nil }()
+
+	token, err := issueReceipt(transferItem{S3Key: "abcde", SHA256: "feed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.SplitN(token, ".", 2)
+	forged, _ := json.Marshal(receipt{Key: "other", SHA256: "feed"})
+
+	other := []byte("other secret")
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"empty", ""},
+		{"no signature", parts[0]},
+		{"bad signature", parts[0] + ".AAAA"},
+		{"swapped payload", base64.RawURLEncoding.EncodeToString(forged) + "." + parts[1]},
+		{"bad base64", "!!." + parts[1]},
+		{"other secret", signWith(other, parts[0])},
+	}
+	for _, tt := range tests {
+		if _, err := parseReceipt(tt.token); err != errBadReceipt {
+			t.Errorf("%s: got %v, want errBadReceipt", tt.name, err)
+		}
+	}
+}
+
+// signWith signs payload as a receipt issued under secret would be.
+func signWith(secret []byte, payload string) string {
+	old := receiptSecret
+	receiptSecret = secret
+	defer func() { receiptSecret = old }()
+	return payload + "." + base64.RawURLEncoding.EncodeToString(receiptMAC(payload))
+}
+
+func TestVerifyReceiptEndpoint(t *testing.T) {
+	useTestStores(t)
+	receiptSecret = []byte("secret")
+	defer func() { receiptSecret = nil }()
+
+	resp := serve(t, testRequest("PUT", "/a.txt", nil, "hello"))
+	token := resp.Headers["X-Receipt"]
+	if token == "" {
+		t.Fatal("no X-Receipt on upload")
+	}
+
+	resp = serve(t, testRequest("GET", "/verify-receipt?receipt="+url.QueryEscape(token), nil, ""))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("verify: %d", resp.StatusCode)
+	}
+	var rc receipt
+	if err := json.Unmarshal([]byte(resp.Body), &rc); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("hello"))
+	if rc.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("receipt hash %s, want the content hash", rc.SHA256)
+	}
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"", http.StatusBadRequest},
+		{"?receipt=" + url.QueryEscape(token[:len(token)-2]), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if resp := serve(t, testRequest("GET", "/verify-receipt"+tt.query, nil, "")); resp.StatusCode != tt.want {
+			t.Errorf("verify %q: %d, want %d", tt.query, resp.StatusCode, tt.want)
+		}
+	}
+
+	receiptSecret = nil
+	if resp := serve(t, testRequest("GET", "/verify-receipt?receipt=x", nil, "")); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("verify without RECEIPT_SECRET: %d, want 404", resp.StatusCode)
+	}
+}
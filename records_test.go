@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// seedRecord stores a record holding only attrs, with its object, the way
+// an older release left it.
+func seedRecord(t *testing.T, st *memoryStore, key string, attrs map[string]*dynamodb.AttributeValue) string {
+	t.Helper()
+
+	av := map[string]*dynamodb.AttributeValue{
+		"s3key":    {S: aws.String(key)},
+		"filename": {S: aws.String("old.txt")},
+		"ip":       {S: aws.String("192.0.2.9")},
+	}
+	for k, v := range attrs {
+		av[k] = v
+	}
+	st.items[key] = av
+
+	if _, err := objects.Put(context.Background(), &object{Key: key, Body: strings.NewReader("hello")}); err != nil {
+		t.Fatal(err)
+	}
+	return "/" + key + "/old.txt"
+}
+
+func TestMixedRecordShapes(t *testing.T) {
+	st, _ := useTestStores(t)
+	setInt(t, &maxDownloads, 2)
+	setString(t, &downloadMode, downloadProxy)
+
+	current, _ := testUpload(t, "new.txt", "hello", nil)
+	oldest := seedRecord(t, st, "old01", nil)
+	counted := seedRecord(t, st, "old02", map[string]*dynamodb.AttributeValue{
+		"times":      numberAttr(1),
+		"created_at": numberAttr(1600000000),
+		"size":       numberAttr(5),
+	})
+
+	// records without max_times follow MAX_DOWNLOADS as it is now
+	setInt(t, &maxDownloads, 4)
+
+	tests := []struct {
+		name, link  string
+		left        string
+		expires     bool
+		downloads   int
+		contentType string
+	}{
+		{"current", current, "2", true, 2, "text/plain; charset=utf-8"},
+		{"oldest", oldest, "4", false, 4, "application/octet-stream"},
+		{"counted", counted, "3", false, 3, "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		resp := serve(t, testRequest("HEAD", tt.link, nil, ""))
+		if resp.StatusCode != http.StatusOK || resp.Headers["X-Downloads-Remaining"] != tt.left {
+			t.Errorf("%s: HEAD %d with %s left, want %s", tt.name, resp.StatusCode, resp.Headers["X-Downloads-Remaining"], tt.left)
+		}
+		if _, ok := resp.Headers["X-Expire-At"]; ok != tt.expires {
+			t.Errorf("%s: X-Expire-At %q", tt.name, resp.Headers["X-Expire-At"])
+		}
+
+		resp = serve(t, testRequest("GET", "/info"+tt.link, nil, ""))
+		var fi fileInfo
+		if err := json.Unmarshal([]byte(resp.Body), &fi); err != nil || resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: info %d %q", tt.name, resp.StatusCode, resp.Body)
+		}
+		if (fi.ExpireAt != 0) != tt.expires {
+			t.Errorf("%s: info expires at %d", tt.name, fi.ExpireAt)
+		}
+
+		for i := 0; i < tt.downloads; i++ {
+			resp := serve(t, testRequest("GET", tt.link, nil, ""))
+			if resp.StatusCode != http.StatusOK || responseBody(t, resp) != "hello" {
+				t.Fatalf("%s: download %d: %d", tt.name, i, resp.StatusCode)
+			}
+			if resp.Headers["Content-Type"] != tt.contentType {
+				t.Errorf("%s: served as %q, want %q", tt.name, resp.Headers["Content-Type"], tt.contentType)
+			}
+		}
+		if resp := serve(t, testRequest("GET", tt.link, nil, "")); resp.StatusCode != http.StatusGone {
+			t.Errorf("%s: download past the limit: %d, want 410", tt.name, resp.StatusCode)
+		}
+	}
+}
+
+func TestDynamoCountDownloadWithoutTimes(t *testing.T) {
+	_, calls := useTestS3(t, ok)
+	st := &dynamoStore{table: "transfers", shards: 1}
+
+	if err := st.CountDownload(context.Background(), "old01", 3); err != nil {
+		t.Fatal(err)
+	}
+	var in dynamodb.UpdateItemInput
+	if err := json.Unmarshal([]byte((*calls)[0].body), &in); err != nil {
+		t.Fatal(err)
+	}
+	if cond := aws.StringValue(in.ConditionExpression); !strings.Contains(cond, "attribute_not_exists(times)") {
+		t.Errorf("condition %q refuses records without times", cond)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// maxPutBackoff caps the wait between two upload attempts.
+const maxPutBackoff = 5 * time.Second
+
+// putBackoff is the full-jitter exponential backoff before retry attempt.
+// A zero S3_PUT_RETRY_BASE retries at once.
+func putBackoff(attempt int) time.Duration {
+	d := putRetryBase << uint(attempt)
+	if d > maxPutBackoff || d < putRetryBase {
+		d = maxPutBackoff
+	}
+	return randDuration(d)
+}
+
+// putConfig configures the S3 client of uploads to retry transient
+// failures up to S3_PUT_RETRIES times, backing off from S3_PUT_RETRY_BASE
+// and within the retry budget of the request. The SDK seeks the body back
+// for every attempt; it is the only retry loop around an upload.
+func putConfig() *aws.Config {
+	cfg := request.WithRetryer(aws.NewConfig(), budgetRetryer{
+		DefaultRetryer: client.DefaultRetryer{NumMaxRetries: putRetries},
+		backoff:        putBackoff,
+	})
+	cfg.EnforceShouldRetryCheck = aws.Bool(true)
+	return cfg
+}
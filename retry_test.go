@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingS3 answers the first failures requests with status, and the rest
+// with success.
+func failingS3(failures, status int) http.HandlerFunc {
+	n := 0
+	return func(w http.ResponseWriter, r *http.Request) {
+		if n++; n <= failures {
+			w.WriteHeader(status)
+		}
+	}
+}
+
+func TestPutRetries(t *testing.T) {
+	setInt(t, &putRetries, 3)
+
+	tests := []struct {
+		name     string
+		failures int
+		status   int
+		attempts int
+		ok       bool
+	}{
+		{"first try", 0, http.StatusServiceUnavailable, 1, true},
+		{"transient", 2, http.StatusServiceUnavailable, 3, true},
+		{"throttled", 1, http.StatusTooManyRequests, 2, true},
+		{"out of retries", 4, http.StatusServiceUnavailable, 4, false},
+		{"not retryable", 1, http.StatusForbidden, 1, false},
+	}
+	for _, tt := range tests {
+		st, calls := useTestS3(t, failingS3(tt.failures, tt.status))
+		_, err := st.Put(context.Background(), &object{Key: "k", Body: strings.NewReader("hello")})
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if len(*calls) != tt.attempts {
+			t.Errorf("%s: %d attempts, want %d", tt.name, len(*calls), tt.attempts)
+		}
+		for i, c := range *calls {
+			if c.body != "hello" {
+				t.Errorf("%s: attempt %d sent %q", tt.name, i, c.body)
+			}
+		}
+	}
+}
+
+func TestPutBackoff(t *testing.T) {
+	setDuration(t, &putRetryBase, 100*time.Millisecond)
+
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{2, 400 * time.Millisecond},
+		{10, maxPutBackoff},
+		{80, maxPutBackoff},
+		{1 << 20, maxPutBackoff},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 50; i++ {
+			if d := putBackoff(tt.attempt); d < 0 || d > tt.max {
+				t.Fatalf("putBackoff(%d) = %v, want at most %v", tt.attempt, d, tt.max)
+			}
+		}
+	}
+
+	setDuration(t, &putRetryBase, 0)
+	if d := putBackoff(3); d != 0 {
+		t.Errorf("putBackoff(3) without a base = %v, want 0", d)
+	}
+}
+
+func TestFailedUploadReleasesKey(t *testing.T) {
+	st, _ := useTestStores(t)
+	setInt(t, &putRetries, 1)
+	s3st, calls := useTestS3(t, failingS3(2, http.StatusServiceUnavailable))
+	objects = s3st
+
+	resp, _ := handleRequest(context.Background(), testRequest("PUT", "/a.txt", nil, "hello"))
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("upload: %d, want 500", resp.StatusCode)
+	}
+	if len(*calls) != 2 {
+		t.Errorf("%d attempts, want 2", len(*calls))
+	}
+	for key := range st.items {
+		if !strings.Contains(key, "#") {
+			t.Errorf("record %s left behind", key)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// rotateKey moves item to a fresh key after a download under
+// ROTATE_ON_ACCESS, so every link works only once. The object stays where
+// it is and the new record points at it. The old record is kept, gone for
+// downloads, to lead the owner's delete token from the key they were given
+// to the current one. It returns the next download url under base.
+//
+// Two downloads racing on the same link both succeed and each hands out a
+// successor; the download counter still holds across all of them, but the
+// owner only reaches the successor recorded last.
+func rotateKey(ctx context.Context, item *transferItem, base string) (string, error) {
+	next := *item
+	next.ObjectKey = item.ObjectPath()
+
+	for {
+		if err := next.GenKey(); err != nil {
+			return "", err
+		}
+		err := meta.Reserve(ctx, &next)
+		if err == nil {
+			break
+		}
+		if err != errKeyExists {
+			return "", err
+		}
+	}
+
+	if err := meta.SetString(ctx, item.S3Key, "rotated_to", next.S3Key); err != nil {
+		// the old link keeps working until it expires or runs out
+		log.Printf("rotate %s: %v", item.S3Key, err)
+	}
+	return base + "/" + next.S3Key + "/" + downloadName(item), nil
+}
+
+// rotatedChain follows item along the keys it was rotated to, returning
+// the record it ended up at and the keys of every record on the way, its
+// own included. A successor that is gone ends the chain early.
+func rotatedChain(ctx context.Context, item *transferItem) (*transferItem, []string, error) {
+	keys := []string{item.S3Key}
+	for item.RotatedTo != "" {
+		next, err := meta.Get(ctx, item.RotatedTo)
+		if err == errNotFound {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		item = next
+		keys = append(keys, item.S3Key)
+	}
+	return item, keys, nil
+}
+
+// rotates reports whether a served download of item moves it to a new key.
+// Vanity slugs keep their name and exhausted uploads have nowhere to go.
+func rotates(item *transferItem) bool {
+	return rotateOnAccess && !item.Slug && item.Times < item.DownloadLimit()
+}
+
+// nextLink is the Link header pointing at the rotated url.
+func nextLink(url string) string {
+	return fmt.Sprintf(`<%s>; rel="next"`, url)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// nextPath is the path of the url in a Link header of rel="next".
+func nextPath(link string) string {
+	if !strings.HasSuffix(link, `>; rel="next"`) {
+		return ""
+	}
+	return strings.TrimPrefix(strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`), testDomain)
+}
+
+func TestRotateOnAccess(t *testing.T) {
+	useTestStores(t)
+	setBool(t, &rotateOnAccess, true)
+	setInt(t, &maxDownloads, 3)
+
+	first, _ := testUpload(t, "a.txt", "hello", nil)
+	tests := []struct {
+		status int
+		next   bool
+	}{
+		{http.StatusFound, true},
+		{http.StatusFound, true},
+		{http.StatusFound, false},
+	}
+
+	link, used := first, []string{}
+	for i, tt := range tests {
+		resp := serve(t, testRequest("GET", link, nil, ""))
+		if resp.StatusCode != tt.status {
+			t.Fatalf("download %d of %s: %d, want %d", i, link, resp.StatusCode, tt.status)
+		}
+		next := nextPath(resp.Headers["Link"])
+		if (next != "") != tt.next {
+			t.Fatalf("download %d: Link %q", i, resp.Headers["Link"])
+		}
+		if next != "" && (keyOf(next) == keyOf(link) || !strings.HasSuffix(next, "/a.txt")) {
+			t.Errorf("download %d: rotated %s to %s", i, link, next)
+		}
+
+		// every link works once
+		used = append(used, link)
+		for _, old := range used {
+			if resp := serve(t, testRequest("GET", old, nil, "")); resp.StatusCode != http.StatusGone {
+				t.Errorf("after download %d: used link %s answered %d", i, old, resp.StatusCode)
+			}
+		}
+		if next != "" {
+			link = next
+		}
+	}
+}
+
+func TestRotatedOwnerDelete(t *testing.T) {
+	st, fs := useTestStores(t)
+	setBool(t, &rotateOnAccess, true)
+	setInt(t, &maxDownloads, 5)
+
+	first, token := testUpload(t, "a.txt", "hello", nil)
+	object := fs.path(keyOf(first))
+
+	link := first
+	for i := 0; i < 2; i++ {
+		resp := serve(t, testRequest("GET", link, nil, ""))
+		link = nextPath(resp.Headers["Link"])
+	}
+	if len(st.items) < 3 {
+		t.Fatalf("%d records after two rotations, want 3", len(st.items))
+	}
+
+	tests := []struct {
+		token string
+		want  int
+	}{
+		{"wrong", http.StatusForbidden},
+		{token, http.StatusNoContent},
+		{token, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if resp := serve(t, testRequest("DELETE", first, map[string]string{"X-Delete-Token": tt.token}, "")); resp.StatusCode != tt.want {
+			t.Errorf("delete with %q: %d, want %d", tt.token, resp.StatusCode, tt.want)
+		}
+	}
+
+	if resp := serve(t, testRequest("GET", link, nil, "")); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("current link after delete: %d, want 404", resp.StatusCode)
+	}
+	if _, err := os.Stat(object); !os.IsNotExist(err) {
+		t.Errorf("object left after delete: %v", err)
+	}
+	for key := range st.items {
+		if !strings.Contains(key, "#") {
+			t.Errorf("record %s left after delete", key)
+		}
+	}
+}
+
+func TestRotatedChain(t *testing.T) {
+	ctx := context.Background()
+	st, _ := useTestStores(t)
+	for _, item := range []transferItem{
+		{S3Key: "aaaaa", RotatedTo: "bbbbb"},
+		{S3Key: "bbbbb", RotatedTo: "ccccc"},
+		{S3Key: "ccccc"},
+		{S3Key: "ddddd", RotatedTo: "missing"},
+	} {
+		item := item
+		item.ExpireAt = 1 << 40
+		if err := st.Reserve(ctx, &item); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		key, end string
+		keys     []string
+	}{
+		{"aaaaa", "ccccc", []string{"aaaaa", "bbbbb", "ccccc"}},
+		{"bbbbb", "ccccc", []string{"bbbbb", "ccccc"}},
+		{"ccccc", "ccccc", []string{"ccccc"}},
+		{"ddddd", "ddddd", []string{"ddddd"}},
+	}
+	for _, tt := range tests {
+		item, _ := st.Get(ctx, tt.key)
+		end, keys, err := rotatedChain(ctx, item)
+		if err != nil || end.S3Key != tt.end || strings.Join(keys, ",") != strings.Join(tt.keys, ",") {
+			t.Errorf("chain of %s: %v, %v, %v", tt.key, end, keys, err)
+		}
+	}
+}
+
+func TestSlugsDoNotRotate(t *testing.T) {
+	useTestStores(t)
+	setBool(t, &rotateOnAccess, true)
+	setBool(t, &vanitySlugs, true)
+	setInt(t, &maxDownloads, 3)
+
+	link, _ := testUpload(t, "a.txt", "hello", map[string]string{"X-Slug": "my-slug"})
+	for i := 0; i < 2; i++ {
+		resp := serve(t, testRequest("GET", link, nil, ""))
+		if resp.StatusCode != http.StatusFound || resp.Headers["Link"] != "" {
+			t.Errorf("download %d: %d, Link %q", i, resp.StatusCode, resp.Headers["Link"])
+		}
+	}
+}
+
+func TestRotationWithExpiryJob(t *testing.T) {
+	useTestStores(t)
+	warned := useTestWebhook(t)
+	setDuration(t, &expiryWarningWindow, 2*time.Hour)
+	setBool(t, &rotateOnAccess, true)
+	setInt(t, &maxDownloads, 10)
+
+	link, _ := testUpload(t, "a.txt", "hello", map[string]string{"X-Expire-Hours": "1"})
+	for i := 0; i < 3; i++ {
+		resp := serve(t, testRequest("GET", link, nil, ""))
+		if link = nextPath(resp.Headers["Link"]); link == "" {
+			t.Fatalf("download %d: %d without a next link", i, resp.StatusCode)
+		}
+	}
+
+	for run := 0; run < 2; run++ {
+		if err := warnExpiring(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(*warned) != 1 || (*warned)[0] != keyOf(link) {
+		t.Errorf("warned %v, want only the live key %s", *warned, keyOf(link))
+	}
+
+	var u quotaUsage
+	if err := json.Unmarshal([]byte(serve(t, testRequest("GET", "/quota", nil, "")).Body), &u); err != nil {
+		t.Fatal(err)
+	}
+	if u.ActiveLinks == nil || *u.ActiveLinks != 1 {
+		t.Errorf("active links %v, want 1", u.ActiveLinks)
+	}
+}
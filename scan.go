@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	scanReject = "reject"
+	scanFlag   = "flag"
+)
+
+// scanRule matches one kind of sensitive data. valid, when set, weeds out
+// regexp matches that are not real hits.
+type scanRule struct {
+	name  string
+	re    *regexp.Regexp
+	valid func(string) bool
+}
+
+var defaultScanRules = []scanRule{
+	{name: "aws_access_key", re: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{name: "private_key", re: regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`)},
+	{name: "credit_card", re: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), valid: luhn},
+}
+
+// parseScanRules reads a JSON object mapping rule names to regexps. An empty
+// string selects the built-in rules.
+func parseScanRules(s string) ([]scanRule, error) {
+	if s == "" {
+		return defaultScanRules, nil
+	}
+
+	var m map[string]string
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		return nil, err
+	}
+
+	rules := make([]scanRule, 0, len(m))
+	for name, expr := range m {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, scanRule{name: name, re: re})
+	}
+
+	sort.Slice(rules, func(i, j int) bool { return rules[i].name < rules[j].name })
+	return rules, nil
+}
+
+// scanContent returns the names of the rules matching body. Binary content
+// is not scanned.
+func scanContent(body []byte) []string {
+	if !strings.HasPrefix(http.DetectContentType(body), "text/") {
+		return nil
+	}
+
+	var hits []string
+	for _, rule := range scanRules {
+		for _, m := range rule.re.FindAll(body, -1) {
+			if rule.valid == nil || rule.valid(string(m)) {
+				hits = append(hits, rule.name)
+				break
+			}
+		}
+	}
+	return hits
+}
+
+// luhn reports whether the digits in s pass the Luhn checksum.
+func luhn(s string) bool {
+	var sum, n int
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n > 0 && sum%10 == 0
+}
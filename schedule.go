@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// expiringSoon is the webhook payload announcing that an upload expires
+// within the warning window.
+type expiringSoon struct {
+	Event    string `json:"event"`
+	Key      string `json:"key"`
+	Filename string `json:"filename"`
+	ExpireAt int64  `json:"expire_at"`
+}
+
+// handleEvent lets one function serve both API Gateway and a CloudWatch
+// Events schedule, telling them apart by the event source.
+func handleEvent(ctx context.Context, raw json.RawMessage) (interface{}, error) {
+	var probe struct {
+		Source string `json:"source"`
+	}
+	if err := json.Unmarshal(raw, &probe); err == nil && probe.Source == "aws.events" {
+		return nil, runScheduled(ctx)
+	}
+
+	var req events.APIGatewayProxyRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return nil, err
+	}
+	return handleRequest(ctx, req)
+}
+
+// runScheduled performs the periodic maintenance jobs.
+func runScheduled(ctx context.Context) error {
+	return warnExpiring(ctx)
+}
+
+// warnExpiring fires a FileExpiringSoon webhook for every upload expiring
+// within expiryWarningWindow. Each upload is warned once: it is marked
+// before the webhook is sent, so that overlapping runs do not both warn it,
+// and unmarked again when the delivery fails, for the next run to retry.
+func warnExpiring(ctx context.Context) error {
+	if expiryWebhookURL == "" || expiryWarningWindow <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	items, err := meta.Expiring(ctx, now.Add(expiryWarningWindow).Unix())
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		ok, err := meta.Claim(ctx, item.S3Key, "warned_at", now.Unix(), 0)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		err = postWebhook(ctx, expiryWebhookURL, expiringSoon{
+			Event:    "FileExpiringSoon",
+			Key:      item.S3Key,
+			Filename: item.Filename,
+			ExpireAt: item.ExpireAt,
+		})
+		if err != nil {
+			log.Printf("expiry warning %s: %v", item.S3Key, err)
+			if err = meta.Unset(ctx, item.S3Key, "warned_at"); err != nil {
+				log.Printf("expiry warning %s: %v", item.S3Key, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+)
+
+// useTestWebhook points the expiry webhook at a receiver that answers each
+// delivery with the next of statuses, then 200, and returns the keys of
+// the deliveries that succeeded.
+func useTestWebhook(t *testing.T, statuses ...int) *[]string {
+	t.Helper()
+
+	var warned []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, webhookSecret)
+		mac.Write(b)
+		if r.Header.Get("X-Signature") != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			t.Errorf("webhook signed %q", r.Header.Get("X-Signature"))
+		}
+
+		if len(statuses) > 0 {
+			status := statuses[0]
+			statuses = statuses[1:]
+			if status != http.StatusOK {
+				w.WriteHeader(status)
+				return
+			}
+		}
+
+		var ev expiringSoon
+		if err := json.Unmarshal(b, &ev); err != nil || ev.Event != "FileExpiringSoon" {
+			t.Errorf("webhook sent %s", b)
+		}
+		warned = append(warned, ev.Key)
+	}))
+	t.Cleanup(srv.Close)
+
+	setString(t, &expiryWebhookURL, srv.URL)
+	old := webhookSecret
+	webhookSecret = []byte("hook secret")
+	t.Cleanup(func() { webhookSecret = old })
+	return &warned
+}
+
+// reserveExpiring stores a record of key expiring in d.
+func reserveExpiring(t *testing.T, key string, d time.Duration) {
+	t.Helper()
+
+	err := meta.Reserve(context.Background(), &transferItem{S3Key: key, Filename: key + ".txt", ExpireAt: time.Now().Add(d).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWarnExpiringThreshold(t *testing.T) {
+	useTestStores(t)
+	warned := useTestWebhook(t)
+	setDuration(t, &expiryWarningWindow, time.Hour)
+
+	reserveExpiring(t, "soon", 10*time.Minute)
+	reserveExpiring(t, "edge", 59*time.Minute)
+	reserveExpiring(t, "later", 2*time.Hour)
+	reserveExpiring(t, "gone", -time.Minute)
+
+	// a second run finds every upload already warned
+	for run := 0; run < 2; run++ {
+		if err := warnExpiring(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sort.Strings(*warned)
+	if len(*warned) != 2 || (*warned)[0] != "edge" || (*warned)[1] != "soon" {
+		t.Errorf("warned %v, want edge and soon once each", *warned)
+	}
+}
+
+func TestWarnExpiringRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		runs     int
+		want     int
+	}{
+		{"delivered", nil, 1, 1},
+		{"failed once", []int{http.StatusInternalServerError}, 1, 0},
+		{"failed, then retried", []int{http.StatusInternalServerError}, 2, 1},
+		{"retried until delivered", []int{http.StatusBadGateway, http.StatusServiceUnavailable}, 4, 1},
+	}
+	for _, tt := range tests {
+		useTestStores(t)
+		warned := useTestWebhook(t, tt.statuses...)
+		setDuration(t, &expiryWarningWindow, time.Hour)
+		reserveExpiring(t, "soon", 10*time.Minute)
+
+		for run := 0; run < tt.runs; run++ {
+			if err := warnExpiring(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if len(*warned) != tt.want {
+			t.Errorf("%s: delivered %d warnings, want %d", tt.name, len(*warned), tt.want)
+		}
+	}
+}
+
+func TestWarnExpiringDisabled(t *testing.T) {
+	useTestStores(t)
+	warned := useTestWebhook(t)
+	setDuration(t, &expiryWarningWindow, 0)
+	reserveExpiring(t, "soon", time.Minute)
+
+	if _, err := handleEvent(context.Background(), json.RawMessage(`{"source": "aws.events"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if len(*warned) != 0 {
+		t.Errorf("warned %v without a window", *warned)
+	}
+}
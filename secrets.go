@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+)
+
+// loadSecrets reads the Secrets Manager secret id, a JSON object such as
+//
+//	{"WEBHOOK_SECRET": "...", "API_KEYS": "..."}
+//
+// and sets each member as an environment variable, where the rest of the
+// configuration picks it up. It runs once per container, so the values are
+// cached for the life of the function; secrets win over plain env vars.
+// It runs before the rest of the configuration, so the session is made from
+// cfg rather than the shared one.
+func loadSecrets(cfg *aws.Config, id string) error {
+	s, err := session.NewSession(cfg)
+	if err != nil {
+		return err
+	}
+
+	out, err := secretsmanager.New(s).GetSecretValue(&secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(id),
+	})
+	if err != nil {
+		return err
+	}
+
+	var values map[string]string
+	if err = json.Unmarshal([]byte(aws.StringValue(out.SecretString)), &values); err != nil {
+		return err
+	}
+	for name, value := range values {
+		if err = os.Setenv(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+// testSecretsConfig points Secrets Manager at a fake answering requests for
+// the secret "transfer" with secret, and any other with status.
+func testSecretsConfig(t *testing.T, secret string, status int) *aws.Config {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue" {
+			t.Errorf("unexpected call %s", r.Header.Get("X-Amz-Target"))
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		var in struct{ SecretId string }
+		json.Unmarshal(b, &in)
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		if in.SecretId != "transfer" {
+			w.WriteHeader(status)
+			w.Write([]byte(`{"__type": "ResourceNotFoundException", "message": "no such secret"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"Name": in.SecretId, "SecretString": secret})
+	}))
+	t.Cleanup(srv.Close)
+
+	return &aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(srv.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:  aws.Int(0),
+	}
+}
+
+func TestLoadSecrets(t *testing.T) {
+	os.Setenv("TEST_WEBHOOK_SECRET", "from env")
+	os.Setenv("TEST_DOMAIN", "https://env.test")
+	defer func() {
+		for _, name := range []string{"TEST_WEBHOOK_SECRET", "TEST_DOMAIN", "TEST_API_KEYS"} {
+			os.Unsetenv(name)
+		}
+	}()
+
+	cfg := testSecretsConfig(t, `{"TEST_WEBHOOK_SECRET": "from secrets", "TEST_API_KEYS": "k1,k2"}`, 0)
+	if err := loadSecrets(cfg, "transfer"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, want string
+	}{
+		{"TEST_WEBHOOK_SECRET", "from secrets"},
+		{"TEST_API_KEYS", "k1,k2"},
+		{"TEST_DOMAIN", "https://env.test"},
+	}
+	for _, tt := range tests {
+		if got := os.Getenv(tt.name); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLoadSecretsFails(t *testing.T) {
+	tests := []struct {
+		name, id, secret string
+	}{
+		{"missing secret", "other", `{}`},
+		{"not an object", "transfer", `["a"]`},
+		{"not a string map", "transfer", `{"API_KEYS": 1}`},
+	}
+	for _, tt := range tests {
+		cfg := testSecretsConfig(t, tt.secret, http.StatusBadRequest)
+		if err := loadSecrets(cfg, tt.id); err == nil {
+			t.Errorf("%s: loaded", tt.name)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	errBadSlug      = errors.New("invalid slug")
+	errSlugTaken    = errors.New("slug taken")
+	errSlugAttempts = errors.New("too many slug attempts")
+)
+
+var slugPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{2,63}$`)
+
+// reservedSlugs are first path segments routed to endpoints rather than
+// uploads.
+var reservedSlugs = map[string]bool{
+	"delete":         true,
+	"info":           true,
+	"quota":          true,
+	"url":            true,
+	"verify-receipt": true,
+}
+
+func validSlug(slug string) bool {
+	return slugPattern.MatchString(slug) && !reservedSlugs[strings.ToLower(slug)]
+}
+
+// normalizeSlug lowercases slug when SLUG_CASE_INSENSITIVE is set, so that
+// it is registered under the form lookup falls back to.
+func normalizeSlug(slug string) string {
+	if slugCaseInsensitive {
+		return strings.ToLower(slug)
+	}
+	return slug
+}
+
+// lookup reads the upload under key. With SLUG_CASE_INSENSITIVE a key that
+// is not found as typed is retried in lowercase, but only a slug may answer
+// the retry: random keys stay case-sensitive.
+func lookup(ctx context.Context, key string) (*transferItem, error) {
+	item, err := meta.Get(ctx, key)
+	if err != errNotFound || !slugCaseInsensitive {
+		return item, err
+	}
+
+	lower := strings.ToLower(key)
+	if lower == key {
+		return nil, errNotFound
+	}
+	item, err = meta.Get(ctx, lower)
+	if err == nil && !item.Slug {
+		return nil, errNotFound
+	}
+	return item, err
+}
+
+// slugHoldKey names the record that remembers who registered slug. It
+// outlives the upload by slugCooldown, so that a deleted or expired slug
+// cannot be taken over by someone else while old links still circulate.
+func slugHoldKey(slug string) string {
+	return "slug#" + slug
+}
+
+// holdSlug reserves slug for uploader until the upload expires plus the
+// cooldown. It fails with errSlugTaken while another uploader holds it.
+func holdSlug(ctx context.Context, slug, uploader string, expireAt time.Time) error {
+	ok, err := meta.Hold(ctx, slugHoldKey(slug), uploader, expireAt.Add(slugCooldown).Unix(), time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errSlugTaken
+	}
+	return nil
+}
+
+// releaseSlug starts the cooldown of a deleted slug upload now, or frees
+// the slug at once when there is no cooldown.
+func releaseSlug(ctx context.Context, item *transferItem) error {
+	if slugCooldown == 0 {
+		return meta.Delete(ctx, slugHoldKey(item.S3Key))
+	}
+	return holdSlug(ctx, item.S3Key, item.Uploader, time.Now())
+}
+
+// slugAttemptKey names the counter of slug registrations from ip in the
+// window starting at start.
+func slugAttemptKey(ip string, start time.Time) string {
+	return fmt.Sprintf("slugs#%s#%d", ip, start.Unix())
+}
+
+// chargeSlugAttempt counts a slug registration from ip, whether or not the
+// slug turns out to be free, and fails with errSlugAttempts once ip has
+// made SLUG_ATTEMPT_LIMIT of them in the current SLUG_ATTEMPT_WINDOW.
+func chargeSlugAttempt(ctx context.Context, ip string) error {
+	if slugAttemptLimit == 0 {
+		return nil
+	}
+
+	start := time.Now().Truncate(slugAttemptWindow)
+	err := meta.Incr(ctx, slugAttemptKey(ip, start), map[string]int64{
+		"attempts": 1,
+	}, map[string]int64{
+		"attempts": int64(slugAttemptLimit),
+	}, start.Add(slugAttemptWindow).Unix())
+	if err == errLimitReached {
+		return errSlugAttempts
+	}
+	return err
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slugUpload uploads under slug from ip and returns the status.
+func slugUpload(t *testing.T, slug, ip string) int {
+	t.Helper()
+
+	req := testRequest("PUT", "/a.txt", map[string]string{"X-Slug": slug}, "hello")
+	req.RequestContext.Identity.SourceIP = ip
+	return serve(t, req).StatusCode
+}
+
+func TestValidSlug(t *testing.T) {
+	tests := []struct {
+		slug string
+		want bool
+	}{
+		{"my-file", true},
+		{"a_b", true},
+		{"ab", false},
+		{"-abc", false},
+		{"has space", false},
+		{"quota", false},
+		{"Delete", false},
+	}
+	for _, tt := range tests {
+		if got := validSlug(tt.slug); got != tt.want {
+			t.Errorf("validSlug(%q) = %v", tt.slug, got)
+		}
+	}
+}
+
+func TestSlugCooldown(t *testing.T) {
+	const (
+		owner = "192.0.2.1"
+		other = "198.51.100.7"
+	)
+
+	tests := []struct {
+		name     string
+		cooldown time.Duration
+		lapsed   bool
+		ip       string
+		want     int
+	}{
+		{"no cooldown", 0, false, other, http.StatusOK},
+		{"other uploader", time.Hour, false, other, http.StatusConflict},
+		{"same uploader", time.Hour, false, owner, http.StatusOK},
+		{"after the cooldown", time.Hour, true, other, http.StatusOK},
+	}
+	for _, tt := range tests {
+		st, _ := useTestStores(t)
+		setBool(t, &vanitySlugs, true)
+		setDuration(t, &slugCooldown, tt.cooldown)
+
+		link, token := testUpload(t, "a.txt", "hello", map[string]string{"X-Slug": "my-slug"})
+		if resp := serve(t, testRequest("DELETE", link, map[string]string{"X-Delete-Token": token}, "")); resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("%s: delete: %d", tt.name, resp.StatusCode)
+		}
+		if tt.lapsed {
+			st.items[slugHoldKey("my-slug")]["expire_at"] = numberAttr(time.Now().Add(-time.Second).Unix())
+		}
+
+		if got := slugUpload(t, "my-slug", tt.ip); got != tt.want {
+			t.Errorf("%s: re-register: %d, want %d", tt.name, got, tt.want)
+		}
+		// a refused upload gives back the record it reserved
+		if _, ok := st.items["my-slug"]; ok != (tt.want == http.StatusOK) {
+			t.Errorf("%s: record kept: %v", tt.name, ok)
+		}
+	}
+}
+
+func TestSlugHeldWhileLive(t *testing.T) {
+	useTestStores(t)
+	setBool(t, &vanitySlugs, true)
+
+	if got := slugUpload(t, "my-slug", "192.0.2.1"); got != http.StatusOK {
+		t.Fatalf("register: %d", got)
+	}
+	for _, ip := range []string{"192.0.2.1", "198.51.100.7"} {
+		if got := slugUpload(t, "my-slug", ip); got != http.StatusConflict {
+			t.Errorf("register again from %s: %d, want 409", ip, got)
+		}
+	}
+}
+
+func TestRefusedSlugKeepsHold(t *testing.T) {
+	st, _ := useTestStores(t)
+	setBool(t, &vanitySlugs, true)
+	setDuration(t, &slugCooldown, time.Hour)
+
+	const ip = "192.0.2.1"
+	hold := func() int64 { return numbers(st.items[slugHoldKey("my-slug")])["expire_at"] }
+
+	tests := []struct {
+		hours string
+		want  int
+	}{
+		{"700", http.StatusOK},
+		{"1", http.StatusConflict},
+	}
+	var until int64
+	for i, tt := range tests {
+		req := testRequest("PUT", "/a.txt", map[string]string{"X-Slug": "my-slug", "X-Expire-Hours": tt.hours}, "hello")
+		req.RequestContext.Identity.SourceIP = ip
+		if got := serve(t, req).StatusCode; got != tt.want {
+			t.Fatalf("upload %d for %sh: %d, want %d", i, tt.hours, got, tt.want)
+		}
+		if i == 0 {
+			until = hold()
+			continue
+		}
+		// the refused upload must not bring the hold forward
+		if got := hold(); got != until {
+			t.Errorf("hold after upload %d: %d, want %d", i, got, until)
+		}
+	}
+}
+
+func TestSlugAttemptLimit(t *testing.T) {
+	useTestStores(t)
+	setBool(t, &vanitySlugs, true)
+	setInt(t, &slugAttemptLimit, 3)
+	setDuration(t, &slugAttemptWindow, time.Hour)
+
+	// collisions count as attempts, and other IPs have their own allowance
+	tests := []struct {
+		slug, ip string
+		want     int
+	}{
+		{"first", "192.0.2.1", http.StatusOK},
+		{"first", "192.0.2.1", http.StatusConflict},
+		{"second", "192.0.2.1", http.StatusOK},
+		{"third", "192.0.2.1", http.StatusTooManyRequests},
+		{"first", "198.51.100.7", http.StatusConflict},
+		{"third", "198.51.100.7", http.StatusOK},
+	}
+	for i, tt := range tests {
+		if got := slugUpload(t, tt.slug, tt.ip); got != tt.want {
+			t.Errorf("attempt %d, %s from %s: %d, want %d", i, tt.slug, tt.ip, got, tt.want)
+		}
+	}
+
+	// plain uploads are not slug attempts
+	if _, token := testUpload(t, "a.txt", "hello", nil); token == "" {
+		t.Error("plain upload refused after the slug limit")
+	}
+}
+
+func TestSlugAttemptsUnlimited(t *testing.T) {
+	useTestStores(t)
+	setBool(t, &vanitySlugs, true)
+	setInt(t, &slugAttemptLimit, 0)
+
+	for i := 0; i < 5; i++ {
+		if got := slugUpload(t, "taken", "192.0.2.1"); i > 0 && got != http.StatusConflict {
+			t.Errorf("attempt %d: %d, want 409", i, got)
+		}
+	}
+}
+
+func TestCaseInsensitiveSlugs(t *testing.T) {
+	useTestStores(t)
+	setBool(t, &vanitySlugs, true)
+	setBool(t, &slugCaseInsensitive, true)
+	setInt(t, &maxDownloads, 100)
+
+	link, _ := testUpload(t, "a.txt", "hello", map[string]string{"X-Slug": "MyFile"})
+	if link != "/myfile/a.txt" {
+		t.Fatalf("slug registered as %s", link)
+	}
+	random, _ := testUpload(t, "b.txt", "hello", nil)
+	upper := "/" + strings.ToUpper(keyOf(random)) + "/b.txt"
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/myfile/a.txt", http.StatusFound},
+		{"/MyFile/a.txt", http.StatusFound},
+		{"/MYFILE/a.txt", http.StatusFound},
+		{random, http.StatusFound},
+		{upper, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if resp := serve(t, testRequest("GET", tt.path, nil, "")); resp.StatusCode != tt.want {
+			t.Errorf("GET %s: %d, want %d", tt.path, resp.StatusCode, tt.want)
+		}
+	}
+
+	if got := slugUpload(t, "MYFILE", "198.51.100.7"); got != http.StatusConflict {
+		t.Errorf("registering MYFILE over myfile: %d, want 409", got)
+	}
+}
+
+func TestCaseSensitiveSlugs(t *testing.T) {
+	useTestStores(t)
+	setBool(t, &vanitySlugs, true)
+	setBool(t, &slugCaseInsensitive, false)
+
+	link, _ := testUpload(t, "a.txt", "hello", map[string]string{"X-Slug": "MyFile"})
+	if link != "/MyFile/a.txt" {
+		t.Fatalf("slug registered as %s", link)
+	}
+	if resp := serve(t, testRequest("GET", "/myfile/a.txt", nil, "")); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET /myfile/a.txt: %d, want 404", resp.StatusCode)
+	}
+	if got := slugUpload(t, "myfile", "198.51.100.7"); got != http.StatusOK {
+		t.Errorf("registering myfile beside MyFile: %d, want 200", got)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+)
+
+// countingStorage counts the objects read from the storage it wraps.
+type countingStorage struct {
+	storage
+	gets int
+}
+
+func (st *countingStorage) Get(ctx context.Context, key, version string) (io.ReadCloser, error) {
+	st.gets++
+	return st.storage.Get(ctx, key, version)
+}
+
+func (st *countingStorage) Peek(ctx context.Context, key, version string, n int64) ([]byte, error) {
+	st.gets++
+	return st.storage.Peek(ctx, key, version, n)
+}
+
+// legacyUpload stores body as name and strips the record down to what it
+// held before content types were recorded.
+func legacyUpload(t *testing.T, st *memoryStore, name, body string) string {
+	link, _ := testUpload(t, name, body, nil)
+	delete(st.items[keyOf(link)], "content_type")
+	delete(st.items[keyOf(link)], "sniffed_type")
+	return link
+}
+
+func TestSniffLegacyType(t *testing.T) {
+	tests := []struct {
+		method, mode string
+		sniff        bool
+		want         string
+	}{
+		{"HEAD", downloadRedirect, true, "image/png"},
+		{"GET", downloadProxy, true, "image/png"},
+		{"HEAD", downloadRedirect, false, ""},
+		{"GET", downloadProxy, false, "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		st, fs := useTestStores(t)
+		counted := &countingStorage{storage: fs}
+		setBool(t, &sniffLegacy, tt.sniff)
+		setString(t, &downloadMode, tt.mode)
+		setInt(t, &maxDownloads, 10)
+
+		link := legacyUpload(t, st, "cat.bin", pngHead)
+		objects = counted
+
+		for i := 0; i < 2; i++ {
+			resp := serve(t, testRequest(tt.method, link, nil, ""))
+			if resp.StatusCode != http.StatusOK || resp.Headers["Content-Type"] != tt.want {
+				t.Errorf("%s, sniff %v, request %d: %d as %q, want %q", tt.method, tt.sniff, i, resp.StatusCode, resp.Headers["Content-Type"], tt.want)
+			}
+		}
+
+		item, err := meta.Get(context.Background(), keyOf(link))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.sniff && (item.ContentType != "image/png" || item.SniffedType != "image/png") {
+			t.Errorf("%s: recorded %q, %q", tt.method, item.ContentType, item.SniffedType)
+		}
+		if !tt.sniff && item.ContentType != "" {
+			t.Errorf("%s without SNIFF_LEGACY: recorded %q", tt.method, item.ContentType)
+		}
+
+		// the sniff reads the object once; proxied downloads read it anyway
+		reads := 0
+		if tt.sniff {
+			reads = 1
+		}
+		if tt.mode == downloadProxy {
+			reads += 2
+		}
+		if counted.gets != reads {
+			t.Errorf("%s, sniff %v: read the object %d times, want %d", tt.method, tt.sniff, counted.gets, reads)
+		}
+	}
+}
+
+func TestSniffLegacyTypeKeepsRecorded(t *testing.T) {
+	_, fs := useTestStores(t)
+	counted := &countingStorage{storage: fs}
+	setBool(t, &sniffLegacy, true)
+
+	link, _ := testUpload(t, "a.txt", "hello", map[string]string{"Content-Type": "text/plain"})
+	objects = counted
+	if resp := serve(t, testRequest("HEAD", link, nil, "")); resp.Headers["Content-Type"] != "text/plain" || counted.gets != 0 {
+		t.Errorf("typed record: %q after %d reads", resp.Headers["Content-Type"], counted.gets)
+	}
+}
+
+func TestSniffLegacyTypeMissingObject(t *testing.T) {
+	st, fs := useTestStores(t)
+	setBool(t, &sniffLegacy, true)
+
+	link := legacyUpload(t, st, "cat.bin", pngHead)
+	item, _ := meta.Get(context.Background(), keyOf(link))
+	if err := os.Remove(fs.path(item.ObjectPath())); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := serve(t, testRequest("HEAD", link, nil, ""))
+	if resp.StatusCode != http.StatusOK || resp.Headers["Content-Type"] != "" {
+		t.Errorf("HEAD of a missing object: %d as %q", resp.StatusCode, resp.Headers["Content-Type"])
+	}
+	if _, ok := st.items[keyOf(link)]["content_type"]; ok {
+		t.Error("failed sniff recorded a type")
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// PUBLIC_READ modes: never store public objects, let uploads opt in with
+// X-Public: true, or make every upload public.
+const (
+	publicNever    = "never"
+	publicOptional = "optional"
+	publicAlways   = "always"
+)
+
+// object is an upload as handed to a storage backend.
+type object struct {
+	Key                string
+	Body               io.ReadSeeker
+	ContentType        string
+	ContentDisposition string
+	Public             bool
+}
+
+// urlOptions adjust the response served through a download url.
+type urlOptions struct {
+	// ContentDisposition overrides the disposition stored with the object.
+	ContentDisposition string
+
+	// VersionID picks a version of the object in a versioned bucket.
+	VersionID string
+}
+
+// storage holds the uploaded files themselves. The transfer records live
+// separately in DynamoDB.
+type storage interface {
+	// Put stores obj, replacing any object under the same key. It returns
+	// the version the store gave the object, or "" when it keeps none.
+	Put(ctx context.Context, obj *object) (string, error)
+
+	// Get opens the object for reading, at version unless that is "".
+	Get(ctx context.Context, key, version string) (io.ReadCloser, error)
+
+	// Peek returns the first n bytes of the object, at version unless that
+	// is "", reading no more of it than that. Shorter objects are returned
+	// whole.
+	Peek(ctx context.Context, key, version string, n int64) ([]byte, error)
+
+	// URL returns an address the object can be downloaded from for ttl.
+	URL(ctx context.Context, key string, ttl time.Duration, opts urlOptions) (string, error)
+
+	// PublicURL returns the permanent address of a public object, either in the
+	// bucket itself or behind the CDN configured as PUBLIC_BASE_URL.
+	PublicURL(key string) string
+
+	// Delete removes the object, for good at version unless that is "".
+	// Deleting a missing object is not an error.
+	Delete(ctx context.Context, key, version string) error
+
+	// Exists reports whether there is an object under key, at version
+	// unless that is "".
+	Exists(ctx context.Context, key, version string) (bool, error)
+}
+
+// newStorage selects the backend named by STORAGE_BACKEND.
+func newStorage(backend string) (storage, error) {
+	switch backend {
+	case "", "s3":
+		return &s3Storage{bucket: s3Bucket}, nil
+	case "fs":
+		dir := os.Getenv("STORAGE_DIR")
+		if dir == "" {
+			return nil, fmt.Errorf("STORAGE_DIR is required for the fs backend")
+		}
+		return &fsStorage{dir: dir, baseURL: os.Getenv("STORAGE_BASE_URL")}, nil
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", backend)
+}
+
+// escapeKey escapes a storage key for use in a url path, keeping the
+// slashes of prefixed keys.
+func escapeKey(key string) string {
+	parts := strings.Split(key, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return strings.Join(parts, "/")
+}
+
+type s3Storage struct {
+	bucket string
+}
+
+func (st *s3Storage) Put(ctx context.Context, obj *object) (string, error) {
+	in := &s3.PutObjectInput{
+		Bucket: aws.String(st.bucket),
+		Key:    aws.String(obj.Key),
+		Body:   obj.Body,
+
+		ContentType:        aws.String(obj.ContentType),
+		ContentDisposition: aws.String(obj.ContentDisposition),
+	}
+	// objects are private by default, and buckets with ACLs disabled, the
+	// default for new ones, refuse any ACL header
+	if obj.Public {
+		in.ACL = aws.String(s3.ObjectCannedACLPublicRead)
+	}
+
+	out, err := s3.New(sess, putConfig()).PutObjectWithContext(ctx, in)
+	if err != nil {
+		return "", err
+	}
+	// unversioned buckets return no version id
+	return aws.StringValue(out.VersionId), nil
+}
+
+func (st *s3Storage) Get(ctx context.Context, key, version string) (io.ReadCloser, error) {
+	in := &s3.GetObjectInput{
+		Bucket: aws.String(st.bucket),
+		Key:    aws.String(key),
+	}
+	if version != "" {
+		in.VersionId = aws.String(version)
+	}
+
+	out, err := s3.New(sess).GetObjectWithContext(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (st *s3Storage) Peek(ctx context.Context, key, version string, n int64) ([]byte, error) {
+	in := &s3.GetObjectInput{
+		Bucket: aws.String(st.bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=0-%d", n-1)),
+	}
+	if version != "" {
+		in.VersionId = aws.String(version)
+	}
+
+	out, err := s3.New(sess).GetObjectWithContext(ctx, in)
+	// S3 refuses any range of an empty object
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "InvalidRange" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	return ioutil.ReadAll(io.LimitReader(out.Body, n))
+}
+
+func (st *s3Storage) URL(ctx context.Context, key string, ttl time.Duration, opts urlOptions) (string, error) {
+	in := &s3.GetObjectInput{
+		Bucket: aws.String(st.bucket),
+		Key:    aws.String(key),
+	}
+	if opts.ContentDisposition != "" {
+		in.ResponseContentDisposition = aws.String(opts.ContentDisposition)
+	}
+	if opts.VersionID != "" {
+		in.VersionId = aws.String(opts.VersionID)
+	}
+
+	objReq, _ := s3.New(sess).GetObjectRequest(in)
+	objReq.SetContext(ctx)
+
+	return objReq.Presign(ttl)
+}
+
+func (st *s3Storage) Exists(ctx context.Context, key, version string) (bool, error) {
+	in := &s3.HeadObjectInput{
+		Bucket: aws.String(st.bucket),
+		Key:    aws.String(key),
+	}
+	if version != "" {
+		in.VersionId = aws.String(version)
+	}
+
+	_, err := s3.New(sess).HeadObjectWithContext(ctx, in)
+	if err == nil {
+		return true, nil
+	}
+	// HEAD answers carry no error body, so S3 reports a plain NotFound
+	if aerr, ok := err.(awserr.Error); ok && (aerr.Code() == "NotFound" || aerr.Code() == s3.ErrCodeNoSuchKey) {
+		return false, nil
+	}
+	return false, err
+}
+
+func (st *s3Storage) PublicURL(key string) string {
+	return publicBaseURL + "/" + escapeKey(key)
+}
+
+// Delete removes the version itself when given one: in a versioned bucket
+// deleting the key alone only hides it behind a delete marker.
+func (st *s3Storage) Delete(ctx context.Context, key, version string) error {
+	in := &s3.DeleteObjectInput{
+		Bucket: aws.String(st.bucket),
+		Key:    aws.String(key),
+	}
+	if version != "" {
+		in.VersionId = aws.String(version)
+	}
+
+	_, err := s3.New(sess).DeleteObjectWithContext(ctx, in)
+	return err
+}
+
+// fsStorage keeps objects as plain files under dir, for local development.
+// Downloads are served from baseURL, e.g. by the dev server.
+type fsStorage struct {
+	dir     string
+	baseURL string
+}
+
+func (st *fsStorage) path(key string) string {
+	return filepath.Join(st.dir, filepath.FromSlash(filepath.Clean("/"+key)))
+}
+
+// Put keeps no versions: a file is simply replaced.
+func (st *fsStorage) Put(ctx context.Context, obj *object) (string, error) {
+	p := st.path(obj.Key)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return "", err
+	}
+
+	f, err := os.Create(p)
+	if err != nil {
+		return "", err
+	}
+	if _, err = io.Copy(f, obj.Body); err != nil {
+		f.Close()
+		os.Remove(p)
+		return "", err
+	}
+	return "", f.Close()
+}
+
+func (st *fsStorage) Get(ctx context.Context, key, version string) (io.ReadCloser, error) {
+	return os.Open(st.path(key))
+}
+
+func (st *fsStorage) Peek(ctx context.Context, key, version string, n int64) ([]byte, error) {
+	f, err := os.Open(st.path(key))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(io.LimitReader(f, n))
+}
+
+// URL needs no signature: the files are only reachable through baseURL.
+// Plain file serving cannot honour opts.
+func (st *fsStorage) URL(ctx context.Context, key string, ttl time.Duration, opts urlOptions) (string, error) {
+	return st.PublicURL(key), nil
+}
+
+func (st *fsStorage) Exists(ctx context.Context, key, version string) (bool, error) {
+	_, err := os.Stat(st.path(key))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (st *fsStorage) PublicURL(key string) string {
+	return st.baseURL + "/" + escapeKey(key)
+}
+
+func (st *fsStorage) Delete(ctx context.Context, key, version string) error {
+	err := os.Remove(st.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// s3Call is a request the fake S3 of useTestS3 received.
+type s3Call struct {
+	method string
+	path   string
+	query  string
+	header http.Header
+	body   string
+}
+
+// useTestS3 points the AWS session at a fake S3 answering every request
+// with handler, and records the requests into calls.
+func useTestS3(t *testing.T, handler http.HandlerFunc) (*s3Storage, *[]s3Call) {
+	t.Helper()
+
+	var calls []s3Call
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		calls = append(calls, s3Call{r.Method, r.URL.Path, r.URL.RawQuery, r.Header, string(b)})
+		handler(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	old := sess
+	sess = session.Must(session.NewSession(&aws.Config{
+		Region:           aws.String("us-east-1"),
+		Endpoint:         aws.String(srv.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+	t.Cleanup(func() { sess = old })
+	setDuration(t, &putRetryBase, 0)
+
+	return &s3Storage{bucket: "bucket"}, &calls
+}
+
+func ok(w http.ResponseWriter, r *http.Request) {}
+
+func TestS3PutACL(t *testing.T) {
+	st, calls := useTestS3(t, ok)
+
+	tests := []struct {
+		public bool
+		acl    string
+	}{
+		{false, ""},
+		{true, "public-read"},
+	}
+	for _, tt := range tests {
+		*calls = nil
+		_, err := st.Put(context.Background(), &object{Key: "k", Body: strings.NewReader("x"), Public: tt.public})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := (*calls)[0].header.Get("X-Amz-Acl"); got != tt.acl {
+			t.Errorf("public %v: ACL %q, want %q", tt.public, got, tt.acl)
+		}
+	}
+}
+
+func TestPublicURL(t *testing.T) {
+	setString(t, &publicBaseURL, "https://cdn.test")
+	st := &s3Storage{bucket: "bucket"}
+	if got := st.PublicURL("2024/06/01/ab cd"); got != "https://cdn.test/2024/06/01/ab%20cd" {
+		t.Errorf("PublicURL = %q", got)
+	}
+}
+
+func TestPublicDownload(t *testing.T) {
+	useTestStores(t)
+	setString(t, &publicRead, publicOptional)
+
+	// the fs backend serves public and private objects from the same url,
+	// so only the count tells them apart
+	tests := []struct {
+		header  string
+		counted int
+	}{
+		{"true", 0},
+		{"", 1},
+	}
+	for _, tt := range tests {
+		link, _ := testUpload(t, "a.txt", "hello", map[string]string{"X-Public": tt.header})
+		key := keyOf(link)
+
+		resp := serve(t, testRequest("GET", link, nil, ""))
+		if resp.StatusCode != http.StatusFound || resp.Headers["Location"] != "https://files.test/"+key {
+			t.Errorf("X-Public %q: %d to %q", tt.header, resp.StatusCode, resp.Headers["Location"])
+		}
+		item, err := meta.Get(context.Background(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item.Public != (tt.header == "true") || item.Times != tt.counted {
+			t.Errorf("X-Public %q: public %v, %d downloads counted", tt.header, item.Public, item.Times)
+		}
+	}
+}
+
+func TestNewStorage(t *testing.T) {
+	tests := []struct {
+		backend string
+		dir     string
+		want    string
+	}{
+		{"", "", "*main.s3Storage"},
+		{"s3", "", "*main.s3Storage"},
+		{"fs", "/tmp/objects", "*main.fsStorage"},
+		{"fs", "", ""},
+		{"gcs", "", ""},
+	}
+	for _, tt := range tests {
+		os.Setenv("STORAGE_DIR", tt.dir)
+		st, err := newStorage(tt.backend)
+		if got := fmt.Sprintf("%T", st); tt.want == "" && err == nil || tt.want != "" && got != tt.want {
+			t.Errorf("backend %q: %s, %v", tt.backend, got, err)
+		}
+	}
+	os.Unsetenv("STORAGE_DIR")
+}
+
+func TestFSStorage(t *testing.T) {
+	ctx := context.Background()
+	st := &fsStorage{dir: t.TempDir(), baseURL: "https://files.test"}
+
+	tests := []struct {
+		key  string
+		path string
+	}{
+		{"abcde", "abcde"},
+		{"2024/06/01/abcde", "2024/06/01/abcde"},
+		{"../../escape", "escape"},
+	}
+	for _, tt := range tests {
+		if _, err := st.Put(ctx, &object{Key: tt.key, Body: strings.NewReader("hello")}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(st.dir, filepath.FromSlash(tt.path))); err != nil {
+			t.Errorf("%q: not stored at %s: %v", tt.key, tt.path, err)
+		}
+
+		rc, err := st.Get(ctx, tt.key, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		if string(b) != "hello" {
+			t.Errorf("%q: read %q", tt.key, b)
+		}
+
+		if b, err := st.Peek(ctx, tt.key, "", 2); string(b) != "he" || err != nil {
+			t.Errorf("%q: peeked %q, %v", tt.key, b, err)
+		}
+
+		if ok, err := st.Exists(ctx, tt.key, ""); !ok || err != nil {
+			t.Errorf("%q: exists %v, %v", tt.key, ok, err)
+		}
+		if err := st.Delete(ctx, tt.key, ""); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := st.Exists(ctx, tt.key, ""); ok || err != nil {
+			t.Errorf("%q: exists after delete %v, %v", tt.key, ok, err)
+		}
+		if err := st.Delete(ctx, tt.key, ""); err != nil {
+			t.Errorf("%q: deleting again: %v", tt.key, err)
+		}
+	}
+}
+
+func TestS3Peek(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		status  int
+		body    string
+		want    string
+	}{
+		{"partial", "", http.StatusPartialContent, "hello", "hello"},
+		{"version", "v1", http.StatusPartialContent, "hello", "hello"},
+		// the server ignoring the range must not make the read longer
+		{"whole object", "", http.StatusOK, strings.Repeat("x", 600), strings.Repeat("x", 512)},
+		{"empty object", "", http.StatusRequestedRangeNotSatisfiable, "<Error><Code>InvalidRange</Code></Error>", ""},
+	}
+	for _, tt := range tests {
+		tt := tt
+		st, calls := useTestS3(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			io.WriteString(w, tt.body)
+		})
+
+		b, err := st.Peek(context.Background(), "k", tt.version, 512)
+		if err != nil || string(b) != tt.want {
+			t.Errorf("%s: peeked %d bytes, %v; want %d", tt.name, len(b), err, len(tt.want))
+		}
+		if len(*calls) != 1 {
+			t.Fatalf("%s: %d calls", tt.name, len(*calls))
+		}
+		call := (*calls)[0]
+		if got := call.header.Get("Range"); got != "bytes=0-511" {
+			t.Errorf("%s: Range %q", tt.name, got)
+		}
+		if tt.version != "" && !strings.Contains(call.query, "versionId="+tt.version) {
+			t.Errorf("%s: query %q", tt.name, call.query)
+		}
+	}
+}
+
+func TestDeleteRemovesObject(t *testing.T) {
+	_, fs := useTestStores(t)
+
+	link, token := testUpload(t, "a.txt", "hello", nil)
+	key := keyOf(link)
+	if _, err := os.Stat(fs.path(key)); err != nil {
+		t.Fatalf("upload not stored: %v", err)
+	}
+
+	resp := serve(t, testRequest("DELETE", link, map[string]string{"X-Delete-Token": token}, ""))
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete: %d", resp.StatusCode)
+	}
+	if _, err := os.Stat(fs.path(key)); !os.IsNotExist(err) {
+		t.Errorf("object left behind: %v", err)
+	}
+}
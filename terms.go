@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// acceptedTerms reports whether an upload may go ahead under TERMS_URL:
+// either no terms are configured or the uploader sent X-Accept-Terms: true.
+func acceptedTerms(req events.APIGatewayProxyRequest) bool {
+	return termsURL == "" || header(req, "X-Accept-Terms") == "true"
+}
+
+// refuseTerms answers an upload that did not accept the terms, pointing the
+// uploader at them.
+func refuseTerms(resp *events.APIGatewayProxyResponse) {
+	resp.StatusCode = http.StatusUnavailableForLegalReasons
+	resp.Headers = map[string]string{
+		"Link": fmt.Sprintf(`<%s>; rel="terms-of-service"`, termsURL),
+	}
+	resp.Body = fmt.Sprintf("accept the terms at %s with X-Accept-Terms: true\n", termsURL)
+}
+
+// auditTerms records that the upload stored under key accepted the terms.
+func auditTerms(ctx context.Context, key, ip string) {
+	if termsURL == "" {
+		return
+	}
+	audit(ctx, auditEvent{
+		Event: "terms_accepted",
+		Key:   key,
+		IP:    ip,
+		Details: map[string]string{
+			"terms": termsURL,
+		},
+	})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestTermsGate(t *testing.T) {
+	useTestStores(t)
+	setString(t, &auditTable, "")
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	tests := []struct {
+		terms   string
+		accept  string
+		status  int
+		audited bool
+	}{
+		{"", "", http.StatusOK, false},
+		{"https://transfer.test/terms", "true", http.StatusOK, true},
+		{"https://transfer.test/terms", "", http.StatusUnavailableForLegalReasons, false},
+		{"https://transfer.test/terms", "yes", http.StatusUnavailableForLegalReasons, false},
+	}
+	for _, tt := range tests {
+		setString(t, &termsURL, tt.terms)
+		logged.Reset()
+
+		resp := serve(t, testRequest("PUT", "/a.txt", map[string]string{"X-Accept-Terms": tt.accept}, "hello"))
+		if resp.StatusCode != tt.status {
+			t.Errorf("terms %q, accept %q: %d, want %d", tt.terms, tt.accept, resp.StatusCode, tt.status)
+		}
+		if tt.status == http.StatusUnavailableForLegalReasons {
+			if link := resp.Headers["Link"]; link != `<https://transfer.test/terms>; rel="terms-of-service"` {
+				t.Errorf("accept %q: Link %q", tt.accept, link)
+			}
+			if !strings.Contains(resp.Body, tt.terms) {
+				t.Errorf("accept %q: body %q does not point at the terms", tt.accept, resp.Body)
+			}
+		}
+
+		audited := strings.Contains(logged.String(), `"event":"terms_accepted"`)
+		if audited != tt.audited {
+			t.Errorf("terms %q, accept %q: audited %v: %s", tt.terms, tt.accept, audited, logged.String())
+		}
+		if audited && !strings.Contains(logged.String(), `"terms":"https://transfer.test/terms"`) {
+			t.Errorf("audit entry lacks the terms: %s", logged.String())
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// UNIQUE_FILENAMES modes for an authenticated uploader reusing the filename
+// of one of their active links: refuse the upload, or number the new
+// filename like "report-2.pdf". Unset allows duplicates.
+const (
+	uniqueReject  = "reject"
+	uniqueVersion = "version"
+)
+
+var errDuplicateFilename = errors.New("duplicate filename")
+
+// uniqueFilename applies UNIQUE_FILENAMES to an upload of filename by
+// uploader, returning the filename to store it under. It finds the
+// uploader's links through the uploader index, which init requires, and
+// filters them by filename here: there is no index on uploader and
+// filename together. The check is not atomic with the upload, so two
+// concurrent uploads of the same name can both get it.
+func uniqueFilename(ctx context.Context, uploader, filename string) (string, error) {
+	items, err := meta.ByUploader(ctx, uploader)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	taken := map[string]bool{}
+	for _, item := range items {
+		if itemStatus(item, now) == http.StatusOK {
+			taken[item.Filename] = true
+		}
+	}
+	if !taken[filename] {
+		return filename, nil
+	}
+	if uniqueFilenames == uniqueReject {
+		return "", errDuplicateFilename
+	}
+
+	ext := path.Ext(filename)
+	base := strings.TrimSuffix(filename, ext)
+	for n := 2; ; n++ {
+		if name := fmt.Sprintf("%s-%d%s", base, n, ext); !taken[name] {
+			return name, nil
+		}
+	}
+}
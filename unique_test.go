@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"path"
+	"testing"
+)
+
+func TestUniqueFilenames(t *testing.T) {
+	old := apiKeys
+	apiKeys = map[string]bool{"k1": true, "k2": true}
+	defer func() { apiKeys = old }()
+
+	k1 := map[string]string{"X-API-Key": "k1"}
+	k2 := map[string]string{"X-API-Key": "k2"}
+
+	tests := []struct {
+		mode  string
+		names []string
+		last  int
+	}{
+		{"", []string{"report.pdf", "report.pdf"}, http.StatusOK},
+		{uniqueReject, []string{"report.pdf"}, http.StatusConflict},
+		{uniqueVersion, []string{"report.pdf", "report-2.pdf", "report-3.pdf"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		useTestStores(t)
+		setString(t, &uniqueFilenames, tt.mode)
+
+		for i, want := range tt.names {
+			link, _ := testUpload(t, "report.pdf", "%PDF-1.4", k1)
+			if got := path.Base(link); got != want {
+				t.Errorf("mode %q: upload %d named %q, want %q", tt.mode, i, got, want)
+			}
+		}
+		resp := serve(t, testRequest("PUT", "/report.pdf", k1, "%PDF-1.4"))
+		if resp.StatusCode != tt.last {
+			t.Errorf("mode %q: last upload %d, want %d", tt.mode, resp.StatusCode, tt.last)
+		}
+
+		// only the uploader's own links count, and anonymous uploads are free
+		for _, headers := range []map[string]string{k2, nil} {
+			if link, _ := testUpload(t, "report.pdf", "%PDF-1.4", headers); path.Base(link) != "report.pdf" {
+				t.Errorf("mode %q: other uploader got %s", tt.mode, link)
+			}
+		}
+	}
+}
+
+func TestUniqueFilenameFreedByExpiry(t *testing.T) {
+	old := apiKeys
+	apiKeys = map[string]bool{"k1": true}
+	defer func() { apiKeys = old }()
+
+	for _, mode := range []string{uniqueReject, uniqueVersion} {
+		useTestStores(t)
+		setString(t, &uniqueFilenames, mode)
+		setInt(t, &maxDownloads, 1)
+
+		headers := map[string]string{"X-API-Key": "k1"}
+		link, _ := testUpload(t, "a.txt", "hello", headers)
+		if resp := serve(t, testRequest("GET", link, nil, "")); resp.StatusCode != http.StatusFound {
+			t.Fatalf("mode %q: download %d", mode, resp.StatusCode)
+		}
+
+		if link, _ := testUpload(t, "a.txt", "again", headers); path.Base(link) != "a.txt" {
+			t.Errorf("mode %q: used up link still holds the name, got %s", mode, link)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// versionedBucket answers as a versioned bucket does, with every object
+// stored as version "v1".
+func versionedBucket(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "PUT" {
+		w.Header().Set("X-Amz-Version-Id", "v1")
+	}
+	if r.Method == "GET" {
+		w.Write([]byte("hello"))
+	}
+}
+
+// unversionedBucket stores objects without versions.
+func unversionedBucket(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		w.Write([]byte("hello"))
+	}
+}
+
+func TestS3Versions(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		version string
+	}{
+		{"versioned", versionedBucket, "v1"},
+		{"unversioned", unversionedBucket, ""},
+	}
+	for _, tt := range tests {
+		st, calls := useTestS3(t, tt.handler)
+		ctx := context.Background()
+
+		version, err := st.Put(ctx, &object{Key: "k", Body: strings.NewReader("hello")})
+		if err != nil || version != tt.version {
+			t.Fatalf("%s: put stored version %q, %v", tt.name, version, err)
+		}
+
+		*calls = nil
+		rc, err := st.Get(ctx, "k", version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(rc)
+		rc.Close()
+		if _, err := st.Exists(ctx, "k", version); err != nil {
+			t.Fatal(err)
+		}
+		if err := st.Delete(ctx, "k", version); err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range *calls {
+			if strings.Contains(c.query, "versionId=v1") != (tt.version != "") {
+				t.Errorf("%s: %s query %q", tt.name, c.method, c.query)
+			}
+		}
+
+		u, err := st.URL(ctx, "k", time.Minute, urlOptions{VersionID: version})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(u, "versionId=v1") != (tt.version != "") {
+			t.Errorf("%s: presigned %s", tt.name, u)
+		}
+	}
+}
+
+func TestVersionedDownloads(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		version string
+	}{
+		{"versioned", versionedBucket, "v1"},
+		{"unversioned", unversionedBucket, ""},
+	}
+	for _, tt := range tests {
+		mem, _ := useTestStores(t)
+		s3st, calls := useTestS3(t, tt.handler)
+		objects = s3st
+		setInt(t, &maxDownloads, 10)
+		setString(t, &downloadMode, downloadRedirect)
+
+		link, token := testUpload(t, "a.txt", "hello", nil)
+		item, err := meta.Get(context.Background(), keyOf(link))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item.VersionID != tt.version {
+			t.Errorf("%s: recorded version %q", tt.name, item.VersionID)
+		}
+		if _, ok := mem.items[item.S3Key]["version_id"]; ok != (tt.version != "") {
+			t.Errorf("%s: version_id attribute stored %v", tt.name, ok)
+		}
+
+		resp := serve(t, testRequest("GET", link, nil, ""))
+		if resp.StatusCode != http.StatusFound || strings.Contains(resp.Headers["Location"], "versionId=v1") != (tt.version != "") {
+			t.Errorf("%s: redirect %d to %s", tt.name, resp.StatusCode, resp.Headers["Location"])
+		}
+
+		setString(t, &downloadMode, downloadProxy)
+		*calls = nil
+		if resp := serve(t, testRequest("GET", link, nil, "")); resp.StatusCode != http.StatusOK || responseBody(t, resp) != "hello" {
+			t.Errorf("%s: proxied %d %q", tt.name, resp.StatusCode, resp.Body)
+		}
+		if resp := serve(t, testRequest("DELETE", link+"?token="+token, nil, "")); resp.StatusCode != http.StatusNoContent {
+			t.Errorf("%s: delete %d", tt.name, resp.StatusCode)
+		}
+		if len(*calls) != 2 {
+			t.Fatalf("%s: %d S3 calls, want a get and a delete", tt.name, len(*calls))
+		}
+		for _, c := range *calls {
+			if strings.Contains(c.query, "versionId=v1") != (tt.version != "") {
+				t.Errorf("%s: %s query %q", tt.name, c.method, c.query)
+			}
+		}
+	}
+}
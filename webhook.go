@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// postWebhook POSTs event as JSON to url. When WEBHOOK_SECRET is set the body
+// is signed in X-Signature as "sha256=<hex hmac>".
+func postWebhook(ctx context.Context, url string, event interface{}) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	if len(webhookSecret) > 0 {
+		mac := hmac.New(sha256.New, webhookSecret)
+		mac.Write(b)
+		req.Header.Set("X-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	res, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook answered %s", res.Status)
+	}
+	return nil
+}